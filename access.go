@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// access is what an identity is authorized to do: which tools it may call, and which paths,
+// relative to the top of the tree, it may use. Nil Tools allows every tool, and nil Paths
+// allows the whole tree. A nil access allows everything.
+type access struct {
+	Tools []string `json:"tools,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+}
+
+var noAccess = &access{Tools: []string{}, Paths: []string{}}
+
+func (a *access) allowTool(name string) bool {
+	return a == nil || a.Tools == nil || slices.Contains(a.Tools, name)
+}
+
+func (a *access) allowPath(path string) bool {
+	return a == nil || a.Paths == nil || pathsContain(a.Paths, path)
+}
+
+func (a *access) pathVisible(path string) bool {
+	return a == nil || a.Paths == nil || pathsVisible(a.Paths, path)
+}
+
+// accessPolicy maps identities to their access. The access of "*" applies to identities
+// which are not otherwise listed. A nil accessPolicy allows everything to everyone.
+type accessPolicy map[string]*access
+
+func parseAccessPolicy(cnt []byte) (accessPolicy, error) {
+	var policy accessPolicy
+	err := json.Unmarshal(cnt, &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	for identity, a := range policy {
+		if a == nil {
+			return nil, fmt.Errorf("access policy: %q: missing access", identity)
+		}
+		for i, p := range a.Paths {
+			p = path.Clean(p)
+			if !fs.ValidPath(p) {
+				return nil, fmt.Errorf("access policy: %q: bad path: %s", identity, a.Paths[i])
+			}
+			a.Paths[i] = p
+		}
+	}
+	return policy, nil
+}
+
+func loadAccessPolicy(file string) (accessPolicy, error) {
+	cnt, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseAccessPolicy(cnt)
+}
+
+// lookup returns the access of identity. An identity which is not in the policy has no access.
+func (ap accessPolicy) lookup(identity string) *access {
+	if ap == nil {
+		return nil
+	}
+
+	if a, ok := ap[identity]; ok {
+		return a
+	} else if a, ok := ap["*"]; ok {
+		return a
+	}
+	return noAccess
+}
+
+// sessionAccess is the access of a session, which changes when the access policy is reloaded,
+// and the tools which are registered for the session.
+type sessionAccess struct {
+	mu     sync.Mutex
+	access *access
+	tools  []string
+}
+
+func (sa *sessionAccess) get() *access {
+	if sa == nil {
+		return nil
+	}
+
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	return sa.access
+}
+
+func (sa *sessionAccess) set(a *access) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+	sa.access = a
+}
+
+var errUnauthorized = errors.New("not authorized")
+
+func errNotAuthorized(path string) error {
+	return fmt.Errorf("%s: %w", path, errUnauthorized)
+}
+
+// allowed returns true if the session may use path.
+func (ft fileTools) allowed(path string) bool {
+	return ft.roots.contains(path) && ft.access.get().allowPath(path) && !ft.deny.denied(path)
+}
+
+// visible returns true if the session may use path, or if path is a directory on the way to
+// one the session may use.
+func (ft fileTools) visible(path string) bool {
+	return ft.roots.visible(path) && ft.access.get().pathVisible(path) &&
+		!ft.deny.denied(path)
+}
+
+// realPath returns the path which path leads to by following symbolic links, or path if it
+// can't be resolved.
+func (ft fileTools) realPath(path string) string {
+	rp, err := realpath(ft.fs, path)
+	if err != nil {
+		return path
+	}
+	return rp
+}
+
+// linkedPath returns p, which is dir or a path below it, as the same path below realDir, which
+// is the path dir leads to by following symbolic links.
+func linkedPath(p, dir, realDir string) string {
+	if dir == realDir {
+		return p
+	} else if p == dir {
+		return realDir
+	} else if dir == "." {
+		return path.Join(realDir, p)
+	}
+	return path.Join(realDir, strings.TrimPrefix(p, dir+"/"))
+}
+
+// checkAllowed returns an error if the session may not use path, or the path which it leads
+// to by following symbolic links.
+func (ft fileTools) checkAllowed(ctx context.Context, path string) error {
+	err := ft.roots.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{path, ft.realPath(path)} {
+		if !ft.roots.contains(p) {
+			return errOutsideRoots(path)
+		} else if !ft.access.get().allowPath(p) {
+			return errNotAuthorized(path)
+		} else if ft.deny.denied(p) {
+			return errDeniedByPolicy(path)
+		}
+	}
+	return nil
+}
+
+// checkVisible returns an error if path, or the path which it leads to by following symbolic
+// links, is not visible to the session.
+func (ft fileTools) checkVisible(ctx context.Context, path string) error {
+	err := ft.roots.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{path, ft.realPath(path)} {
+		if !ft.roots.visible(p) {
+			return errOutsideRoots(path)
+		} else if !ft.access.get().pathVisible(p) {
+			return errNotAuthorized(path)
+		} else if ft.deny.denied(p) {
+			return errDeniedByPolicy(path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestParseAccessPolicy(t *testing.T) {
+	cases := []struct {
+		cnt    string
+		policy accessPolicy
+		fail   bool
+	}{
+		{cnt: `{}`, policy: accessPolicy{}},
+		{
+			cnt: `{"alice": {"tools": ["read_file"], "paths": ["src/", "docs/./x"]},
+				"*": {}}`,
+			policy: accessPolicy{
+				"alice": {Tools: []string{"read_file"}, Paths: []string{"src", "docs/x"}},
+				"*":     {},
+			},
+		},
+		{cnt: `{"bob": {"tools": [], "paths": []}}`,
+			policy: accessPolicy{"bob": {Tools: []string{}, Paths: []string{}}}},
+		{cnt: `{"alice": null}`, fail: true},
+		{cnt: `{"alice": {"paths": ["../etc"]}}`, fail: true},
+		{cnt: `{"alice": {"paths": ["/etc"]}}`, fail: true},
+		{cnt: `{"alice": {"tools": "read_file"}}`, fail: true},
+		{cnt: `not json`, fail: true},
+	}
+
+	for _, c := range cases {
+		policy, err := parseAccessPolicy([]byte(c.cnt))
+		if err != nil {
+			if !c.fail {
+				t.Errorf("parseAccessPolicy(%s) failed with %s", c.cnt, err)
+			}
+		} else if c.fail {
+			t.Errorf("parseAccessPolicy(%s) did not fail", c.cnt)
+		} else if !reflect.DeepEqual(policy, c.policy) {
+			t.Errorf("parseAccessPolicy(%s) got %v, want %v", c.cnt, policy, c.policy)
+		}
+	}
+}
+
+func TestAccess(t *testing.T) {
+	policy := accessPolicy{
+		"alice": {Tools: []string{"read_file"}, Paths: []string{"src"}},
+		"bob":   {Paths: []string{"docs/public"}},
+		"*":     {Tools: []string{"list_roots"}, Paths: []string{}},
+	}
+
+	cases := []struct {
+		policy   accessPolicy
+		identity string
+		tool     string
+		path     string
+		tAllow   bool
+		pAllow   bool
+		pVisible bool
+	}{
+		{policy: policy, identity: "alice", tool: "read_file", path: "src/main.go",
+			tAllow: true, pAllow: true, pVisible: true},
+		{policy: policy, identity: "alice", tool: "list_directory", path: "docs",
+			tAllow: false, pAllow: false, pVisible: false},
+		{policy: policy, identity: "bob", tool: "list_directory", path: "docs",
+			tAllow: true, pAllow: false, pVisible: true},
+		{policy: policy, identity: "bob", tool: "read_file", path: "docs/public/x",
+			tAllow: true, pAllow: true, pVisible: true},
+		{policy: policy, identity: "carol", tool: "list_roots", path: ".",
+			tAllow: true, pAllow: false, pVisible: true},
+		{policy: policy, identity: "carol", tool: "read_file", path: "src",
+			tAllow: false, pAllow: false, pVisible: false},
+		{policy: accessPolicy{}, identity: "alice", tool: "read_file", path: "src",
+			tAllow: false, pAllow: false, pVisible: false},
+		{policy: nil, identity: "alice", tool: "read_file", path: "src",
+			tAllow: true, pAllow: true, pVisible: true},
+	}
+
+	for _, c := range cases {
+		a := c.policy.lookup(c.identity)
+		if a.allowTool(c.tool) != c.tAllow {
+			t.Errorf("%s: allowTool(%s) got %v", c.identity, c.tool, !c.tAllow)
+		}
+		if a.allowPath(c.path) != c.pAllow {
+			t.Errorf("%s: allowPath(%s) got %v", c.identity, c.path, !c.pAllow)
+		}
+		if a.pathVisible(c.path) != c.pVisible {
+			t.Errorf("%s: pathVisible(%s) got %v", c.identity, c.path, !c.pVisible)
+		}
+	}
+}
+
+func TestAccessTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "src", "main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "docs", "readme.md"), []byte("readme"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		policy: accessPolicy{
+			"alice": {Tools: []string{"read_file", "search_files"}, Paths: []string{"src"}},
+		},
+	}
+
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{identity: "alice"}), opts)
+
+	res, err := cs.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListTools() failed with %s", err)
+	}
+	var tools []string
+	for _, tool := range res.Tools {
+		tools = append(tools, tool.Name)
+	}
+	if !reflect.DeepEqual(tools, []string{"read_file", "search_files"}) {
+		t.Errorf("ListTools() got %v, want read_file and search_files", tools)
+	}
+
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "src/main.go"}); res.IsError {
+		t.Errorf("read_file(src/main.go) failed with %v", res.Content)
+	}
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "docs/readme.md"}); !res.IsError {
+		t.Errorf("read_file(docs/readme.md) did not fail")
+	}
+
+	res2 := callTool(t, cs, "search_files", map[string]any{"pattern": "*"})
+	if res2.IsError {
+		t.Errorf("search_files(*) failed with %v", res2.Content)
+	} else if out := res2.StructuredContent.(map[string]any); out["count"] != 1.0 {
+		t.Errorf("search_files(*) got %v, want src/main.go", out["matches"])
+	}
+
+	_, cs = connectClient(t, sf.newServer(sessionInfo{identity: "bob"}), opts)
+	res, err = cs.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("ListTools() failed with %s", err)
+	} else if len(res.Tools) != 0 {
+		t.Errorf("ListTools() got %d tools for bob, want none", len(res.Tools))
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// auditLog writes a JSON line for each tool call to a file, which is rotated when it
+// reaches maxSize bytes: file is renamed to file.1, file.1 to file.2, and so on, keeping
+// keep old files.
+//
+// The records are a hash chain: each record has the next sequence number and the SHA-256 of
+// the previous line, so that records which are modified, removed, or inserted can be
+// detected. If there is a key, a checkpoint, which signs the chain so far, is written every
+// checkpoint records and when the log is closed.
+type auditLog struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	keep       int
+	file       *os.File
+	size       int64
+	seq        uint64
+	prev       string
+	key        ed25519.PrivateKey
+	checkpoint uint64
+	unsigned   uint64
+}
+
+type auditRecord struct {
+	Seq        uint64          `json:"seq"`
+	Prev       string          `json:"prev"`
+	Type       string          `json:"type"`
+	Time       string          `json:"time"`
+	Session    string          `json:"session,omitempty"`
+	Identity   string          `json:"identity,omitempty"`
+	Transport  string          `json:"transport"`
+	Remote     string          `json:"remote,omitempty"`
+	Tool       string          `json:"tool"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Cwd        string          `json:"cwd,omitempty"`
+	Path       string          `json:"path,omitempty"`
+	Outcome    string          `json:"outcome"`
+	ErrorClass string          `json:"errorClass,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Bytes      int64           `json:"bytes"`
+	LatencyMS  float64         `json:"latencyMs"`
+}
+
+// auditCheckpoint is written to the audit log to sign the chain up to and including the
+// record with hash Prev. Key is the public key which made the signature.
+type auditCheckpoint struct {
+	Seq       uint64 `json:"seq"`
+	Prev      string `json:"prev"`
+	Type      string `json:"type"`
+	Time      string `json:"time"`
+	Key       string `json:"key"`
+	Signature string `json:"signature"`
+}
+
+// checkpointMessage returns what the signature of a checkpoint signs.
+func checkpointMessage(seq uint64, prev string) []byte {
+	return fmt.Appendf(nil, "filemcp audit checkpoint %d %s", seq, prev)
+}
+
+// auditHash returns the hash of a line of the audit log, without the newline.
+func auditHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// openAuditLog opens the audit log at path, and continues its hash chain from the last
+// record, which is in path, or, if it is empty, path.1.
+func openAuditLog(path string, maxSize int64, keep int, key ed25519.PrivateKey,
+	checkpoint uint64) (*auditLog, error) {
+
+	al := &auditLog{
+		path:       path,
+		maxSize:    maxSize,
+		keep:       keep,
+		key:        key,
+		checkpoint: checkpoint,
+	}
+
+	for _, file := range []string{path, path + ".1"} {
+		line, err := lastAuditLine(file)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		} else if line == nil {
+			continue
+		}
+
+		var rec auditRecord
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: last record: %s", file, err)
+		}
+		al.seq = rec.Seq
+		al.prev = auditHash(line)
+		break
+	}
+
+	err := al.open()
+	if err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+// lastAuditLine returns the last line of an audit log file, or nil if it is empty.
+func lastAuditLine(file string) ([]byte, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var last []byte
+	r := bufio.NewReader(fh)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSuffix(line, []byte("\n")); len(line) > 0 {
+			last = line
+		}
+		if err == io.EOF {
+			return last, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (al *auditLog) open() error {
+	file, err := os.OpenFile(al.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	al.file = file
+	al.size = fi.Size()
+	return nil
+}
+
+func (al *auditLog) rotate() error {
+	al.file.Close()
+	al.file = nil
+
+	if al.keep > 0 {
+		for n := al.keep - 1; n > 0; n-- {
+			os.Rename(fmt.Sprintf("%s.%d", al.path, n), fmt.Sprintf("%s.%d", al.path, n+1))
+		}
+		err := os.Rename(al.path, al.path+".1")
+		if err != nil {
+			return err
+		}
+	} else {
+		err := os.Remove(al.path)
+		if err != nil {
+			return err
+		}
+	}
+	return al.open()
+}
+
+func (al *auditLog) write(rec auditRecord) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	rec.Seq = al.seq + 1
+	rec.Prev = al.prev
+	rec.Type = "call"
+	al.writeLine(rec)
+	al.unsigned += 1
+
+	if al.key != nil && al.checkpoint > 0 && al.unsigned >= al.checkpoint {
+		al.writeCheckpoint()
+	}
+}
+
+// writeCheckpoint signs the chain so far; al.mu must be held.
+func (al *auditLog) writeCheckpoint() {
+	seq := al.seq + 1
+	al.writeLine(auditCheckpoint{
+		Seq:  seq,
+		Prev: al.prev,
+		Type: "checkpoint",
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+		Key:  base64.StdEncoding.EncodeToString(al.key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(al.key,
+			checkpointMessage(seq, al.prev))),
+	})
+	al.unsigned = 0
+}
+
+// writeLine appends v, which has the next sequence number and the hash of the previous line,
+// to the hash chain; al.mu must be held.
+func (al *auditLog) writeLine(v any) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("audit log", "error", err)
+		return
+	}
+	al.seq += 1
+	al.prev = auditHash(line)
+	line = append(line, '\n')
+
+	if al.file != nil && al.maxSize > 0 && al.size > 0 &&
+		al.size+int64(len(line)) > al.maxSize {
+
+		err = al.rotate()
+		if err != nil {
+			slog.Error("rotate audit log", "path", al.path, "error", err)
+		}
+	}
+	if al.file == nil {
+		err = al.open()
+		if err != nil {
+			slog.Error("open audit log", "path", al.path, "error", err)
+			return
+		}
+	}
+
+	n, err := al.file.Write(line)
+	al.size += int64(n)
+	if err != nil {
+		slog.Error("audit log", "path", al.path, "error", err)
+	}
+}
+
+func (al *auditLog) close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.file == nil {
+		return nil
+	}
+	if al.key != nil && al.unsigned > 0 {
+		al.writeCheckpoint()
+	}
+	err := al.file.Close()
+	al.file = nil
+	return err
+}
+
+// auditCall collects what a tool handler did during a call: the bytes it read or wrote,
+// and the error it failed with.
+type auditCall struct {
+	mu    sync.Mutex
+	bytes int64
+	err   error
+}
+
+type auditCallKey struct{}
+
+func auditCallFromContext(ctx context.Context) *auditCall {
+	ac, _ := ctx.Value(auditCallKey{}).(*auditCall)
+	return ac
+}
+
+// auditBytes records that n bytes were read or written by the tool call of ctx.
+func auditBytes(ctx context.Context, n int) {
+	if ac := auditCallFromContext(ctx); ac != nil {
+		ac.mu.Lock()
+		ac.bytes += int64(n)
+		ac.mu.Unlock()
+	}
+}
+
+// auditError records that the tool call of ctx failed with err.
+func auditError(ctx context.Context, err error) {
+	if ac := auditCallFromContext(ctx); ac != nil {
+		ac.mu.Lock()
+		ac.err = err
+		ac.mu.Unlock()
+	}
+}
+
+// errorClass returns the class of err, for the audit log: its error code, except that paths
+// which the session isn't authorized to use are told apart from those denied by policy.
+func errorClass(err error) string {
+	if errors.Is(err, errUnauthorized) {
+		return "not_authorized"
+	}
+	return errorCode(err)
+}
+
+// middleware returns middleware which writes an audit record for each tool call of the
+// session. callPaths returns the current directory of the session, and the path argument of
+// a call resolved against it, which are recorded along with the arguments.
+func (al *auditLog) middleware(si sessionInfo,
+	callPaths func(args json.RawMessage) (string, string)) mcp.Middleware {
+
+	return func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			ctr, ok := req.(*mcp.CallToolRequest)
+			if !ok || method != "tools/call" {
+				return next(ctx, method, req)
+			}
+
+			cwd, p := callPaths(ctr.Params.Arguments)
+			ac := &auditCall{}
+			start := time.Now()
+			res, err := next(context.WithValue(ctx, auditCallKey{}, ac), method, req)
+
+			rec := auditRecord{
+				Time:      start.UTC().Format(time.RFC3339Nano),
+				Identity:  si.identity,
+				Transport: si.transport,
+				Remote:    si.remoteAddr,
+				Tool:      ctr.Params.Name,
+				Arguments: ctr.Params.Arguments,
+				Cwd:       cwd,
+				Path:      p,
+				Outcome:   "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if ctr.Session != nil {
+				rec.Session = ctr.Session.ID()
+			}
+
+			ac.mu.Lock()
+			rec.Bytes = ac.bytes
+			callErr := ac.err
+			ac.mu.Unlock()
+
+			if err != nil {
+				callErr = err
+			} else if ctr, ok := res.(*mcp.CallToolResult); ok && ctr.IsError && callErr == nil {
+				callErr = errors.New(resultText(ctr))
+			}
+			if callErr != nil {
+				rec.Outcome = "error"
+				rec.ErrorClass = errorClass(callErr)
+				rec.Error = callErr.Error()
+			}
+
+			al.write(rec)
+			return res, err
+		}
+	}
+}
+
+// resultText returns the text content of a tool result.
+func resultText(res *mcp.CallToolResult) string {
+	var s string
+	for _, c := range res.Content {
+		if tc, ok := c.(*mcp.TextContent); ok {
+			s += tc.Text
+		}
+	}
+	return s
+}
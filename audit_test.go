@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func readAuditRecords(t *testing.T, file string) []auditRecord {
+	t.Helper()
+
+	cnt, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed with %s", file, err)
+	}
+
+	var recs []auditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(cnt))
+	for scanner.Scan() {
+		var rec auditRecord
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			t.Fatalf("Unmarshal(%s) failed with %s", scanner.Bytes(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		err   error
+		class string
+	}{
+		{err: &fs.PathError{Op: "open", Path: "a", Err: fs.ErrNotExist}, class: "not_found"},
+		{err: &fs.PathError{Op: "open", Path: "a", Err: fs.ErrPermission},
+			class: "permission_denied"},
+		{err: errOutsideRoots("a"), class: "outside_roots"},
+		{err: &fs.PathError{Op: "open", Path: "../a", Err: fs.ErrInvalid},
+			class: "path_escape"},
+		{err: errNotAuthorized("a"), class: "not_authorized"},
+		{err: errDeniedByPolicy("a"), class: "policy_denied"},
+		{err: fmt.Errorf("wait: %w", context.Canceled), class: "canceled"},
+		{err: errShuttingDown, class: "shutting_down"},
+		{err: fmt.Errorf("something else"), class: "internal"},
+	}
+
+	for _, c := range cases {
+		if class := errorClass(c.err); class != c.class {
+			t.Errorf("errorClass(%s) got %s, want %s", c.err, class, c.class)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("content"))
+	mustWriteFile(t, filepath.Join(tempDir, ".env"), []byte("SECRET=1"))
+	mustWriteFile(t, filepath.Join(tempDir, "src", "main.go"), []byte("package main"))
+
+	deny, err := parseDenyList(nil, true)
+	if err != nil {
+		t.Fatalf("parseDenyList() failed with %s", err)
+	}
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	al, err := openAuditLog(auditFile, 0, 0, nil, 0)
+	if err != nil {
+		t.Fatalf("openAuditLog(%s) failed with %s", auditFile, err)
+	}
+	defer al.close()
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		deny:        deny,
+		audit:       al,
+	}
+
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{identity: "alice", transport: "stdio"}),
+		opts)
+
+	callTool(t, cs, "read_file", map[string]any{"path": "file.txt"})
+	callTool(t, cs, "read_file", map[string]any{"path": "missing.txt"})
+	callTool(t, cs, "get_file_info", map[string]any{"path": ".env"})
+	callTool(t, cs, "list_roots", map[string]any{})
+	callTool(t, cs, "cd", map[string]any{"path": "src"})
+	callTool(t, cs, "read_file", map[string]any{"path": "main.go"})
+
+	want := []struct {
+		tool       string
+		args       string
+		cwd        string
+		path       string
+		outcome    string
+		errorClass string
+		bytes      int64
+	}{
+		{tool: "read_file", args: `{"path":"file.txt"}`, cwd: ".", path: "file.txt",
+			outcome: "ok", bytes: 7},
+		{tool: "read_file", args: `{"path":"missing.txt"}`, cwd: ".", path: "missing.txt",
+			outcome: "error", errorClass: "not_found"},
+		{tool: "get_file_info", args: `{"path":".env"}`, cwd: ".", path: ".env",
+			outcome: "error", errorClass: "policy_denied"},
+		{tool: "list_roots", args: `{}`, cwd: ".", outcome: "ok"},
+		{tool: "cd", args: `{"path":"src"}`, cwd: ".", path: "src", outcome: "ok"},
+		{tool: "read_file", args: `{"path":"main.go"}`, cwd: "src", path: "src/main.go",
+			outcome: "ok", bytes: 12},
+	}
+
+	recs := readAuditRecords(t, auditFile)
+	if len(recs) != len(want) {
+		t.Fatalf("audit log got %d records, want %d", len(recs), len(want))
+	}
+	for i, w := range want {
+		rec := recs[i]
+		if rec.Tool != w.tool || string(rec.Arguments) != w.args || rec.Cwd != w.cwd ||
+			rec.Path != w.path || rec.Outcome != w.outcome || rec.ErrorClass != w.errorClass ||
+			rec.Bytes != w.bytes {
+
+			t.Errorf("audit record %d got %+v, want %+v", i, rec, w)
+		}
+		if rec.Identity != "alice" || rec.Transport != "stdio" || rec.Time == "" {
+			t.Errorf("audit record %d got %+v", i, rec)
+		}
+		if w.outcome == "error" && rec.Error == "" {
+			t.Errorf("audit record %d: missing error", i)
+		}
+	}
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	al, err := openAuditLog(auditFile, 512, 2, nil, 0)
+	if err != nil {
+		t.Fatalf("openAuditLog(%s) failed with %s", auditFile, err)
+	}
+	defer al.close()
+
+	for i := 0; i < 20; i++ {
+		al.write(auditRecord{
+			Tool:      "read_file",
+			Arguments: json.RawMessage(fmt.Sprintf(`{"path":"file%d.txt"}`, i)),
+			Outcome:   "ok",
+		})
+	}
+
+	for _, file := range []string{auditFile, auditFile + ".1", auditFile + ".2"} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Errorf("Stat(%s) failed with %s", file, err)
+		} else if fi.Size() > 512 {
+			t.Errorf("Stat(%s) got size %d, want at most 512", file, fi.Size())
+		}
+	}
+	if _, err := os.Stat(auditFile + ".3"); err == nil {
+		t.Errorf("Stat(%s.3) did not fail", auditFile)
+	}
+
+	recs := readAuditRecords(t, auditFile)
+	if len(recs) == 0 {
+		t.Fatalf("audit log got no records")
+	} else if want := `{"path":"file19.txt"}`; string(recs[len(recs)-1].Arguments) != want {
+		t.Errorf("last audit record got %s, want %s", recs[len(recs)-1].Arguments, want)
+	}
+}
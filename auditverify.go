@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// loadAuditKey returns the ed25519 private key, which signs audit log checkpoints, in file.
+// If file doesn't exist, a key is generated and written to it, and its public key, which
+// verifies checkpoints, is written to file.pub.
+func loadAuditKey(file string) (ed25519.PrivateKey, error) {
+	cnt, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createAuditKey(file)
+	} else if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(cnt)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: missing private key", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", file)
+	}
+	return edKey, nil
+}
+
+func createAuditKey(file string) (ed25519.PrivateKey, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = writeFileAtomic(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY",
+		Bytes: keyDER}), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	err = writeFileAtomic(file+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY",
+		Bytes: pubDER}), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("created audit key", "key", file, "public", file+".pub")
+	return key, nil
+}
+
+// loadAuditPublicKey returns the ed25519 public key in file, which may also be the private
+// key.
+func loadAuditPublicKey(file string) (ed25519.PublicKey, error) {
+	cnt, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(cnt)
+	if block == nil {
+		return nil, fmt.Errorf("%s: missing public key", file)
+	}
+
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unexpected %s", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	return nil, fmt.Errorf("%s: not an ed25519 key", file)
+}
+
+// auditVerifier checks the hash chain, and the signatures of the checkpoints, of audit log
+// files, in order.
+type auditVerifier struct {
+	key         ed25519.PublicKey
+	started     bool
+	first       uint64
+	seq         uint64
+	prev        string
+	records     int
+	checkpoints int
+	signed      uint64
+	problems    []string
+}
+
+func (av *auditVerifier) problem(file string, line int, format string, args ...any) {
+	av.problems = append(av.problems,
+		fmt.Sprintf("%s:%d: %s", file, line, fmt.Sprintf(format, args...)))
+}
+
+func (av *auditVerifier) verifyFile(file string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return av.verify(file, fh)
+}
+
+func (av *auditVerifier) verify(file string, rd io.Reader) error {
+	r := bufio.NewReader(rd)
+	for num := 1; ; num++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				av.problem(file, num, "incomplete record")
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+
+		var rec auditCheckpoint
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			av.problem(file, num, "bad record: %s", err)
+			continue
+		}
+
+		if !av.started {
+			// Older records might have been rotated away, so the chain starts with the
+			// first record.
+			av.started = true
+			av.first = rec.Seq
+		} else if rec.Seq != av.seq+1 {
+			av.problem(file, num, "sequence %d: expected %d: records are missing", rec.Seq,
+				av.seq+1)
+		} else if rec.Prev != av.prev {
+			av.problem(file, num, "sequence %d: hash of previous record does not match: "+
+				"records were modified", rec.Seq)
+		}
+
+		if rec.Type == "checkpoint" {
+			av.checkpoints += 1
+			av.verifyCheckpoint(file, num, rec)
+		} else {
+			av.records += 1
+		}
+		av.seq = rec.Seq
+		av.prev = auditHash(line)
+	}
+}
+
+func (av *auditVerifier) verifyCheckpoint(file string, num int, rec auditCheckpoint) {
+	if av.key == nil {
+		return
+	}
+
+	key, err := base64.StdEncoding.DecodeString(rec.Key)
+	if err != nil || !bytes.Equal(key, av.key) {
+		av.problem(file, num, "checkpoint %d: signed by a different key", rec.Seq)
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(rec.Signature)
+	if err != nil || !ed25519.Verify(av.key, checkpointMessage(rec.Seq, rec.Prev), sig) {
+		av.problem(file, num, "checkpoint %d: bad signature", rec.Seq)
+		return
+	}
+	av.signed = rec.Seq
+}
+
+// auditFiles returns file and the files it was rotated to, oldest first.
+func auditFiles(file string) []string {
+	files := []string{file}
+	for n := 1; ; n++ {
+		rotated := fmt.Sprintf("%s.%d", file, n)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append([]string{rotated}, files...)
+	}
+	return files
+}
+
+// auditCommand runs the audit subcommand: filemcp audit verify [-key file] auditlog...
+func auditCommand(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintf(os.Stderr, "usage: %s audit verify [-key file] auditlog...\n", os.Args[0])
+		return 2
+	}
+
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	keyFile := flags.String("key", "", "ed25519 public key file which verifies the checkpoints")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s audit verify [-key file] auditlog...\n\n"+
+			"Verify the hash chain, and the checkpoints, of audit logs. A single audit log\n"+
+			"includes the files it was rotated to.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return 2
+	} else if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	av := &auditVerifier{}
+	if *keyFile != "" {
+		av.key, err = loadAuditPublicKey(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+			return 1
+		}
+	}
+
+	files := flags.Args()
+	if len(files) == 1 {
+		files = auditFiles(files[0])
+	}
+	for _, file := range files {
+		err = av.verifyFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+			return 1
+		}
+	}
+
+	for _, p := range av.problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d records and %d checkpoints, sequence %d to %d\n", av.records,
+		av.checkpoints, av.first, av.seq)
+	if av.key != nil {
+		if av.signed == 0 {
+			fmt.Println("no signed checkpoints")
+		} else if av.signed < av.seq {
+			fmt.Printf("records after sequence %d are not signed\n", av.signed)
+		}
+	}
+	if len(av.problems) > 0 {
+		fmt.Println("FAILED")
+		return 1
+	}
+	fmt.Println("OK")
+	return 0
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAuditKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "audit.key")
+
+	key, err := loadAuditKey(keyFile)
+	if err != nil {
+		t.Fatalf("loadAuditKey(%s) failed with %s", keyFile, err)
+	}
+	key2, err := loadAuditKey(keyFile)
+	if err != nil {
+		t.Fatalf("loadAuditKey(%s) failed with %s", keyFile, err)
+	} else if !key.Equal(key2) {
+		t.Errorf("loadAuditKey(%s) did not reuse the key", keyFile)
+	}
+
+	for _, file := range []string{keyFile, keyFile + ".pub"} {
+		pub, err := loadAuditPublicKey(file)
+		if err != nil {
+			t.Errorf("loadAuditPublicKey(%s) failed with %s", file, err)
+		} else if !pub.Equal(key.Public()) {
+			t.Errorf("loadAuditPublicKey(%s) got a different key", file)
+		}
+	}
+}
+
+func writeAuditRecords(t *testing.T, auditFile string, key ed25519.PrivateKey, start, n int) {
+	t.Helper()
+
+	al, err := openAuditLog(auditFile, 0, 0, key, 3)
+	if err != nil {
+		t.Fatalf("openAuditLog(%s) failed with %s", auditFile, err)
+	}
+	for i := start; i < start+n; i++ {
+		al.write(auditRecord{
+			Tool:      "read_file",
+			Arguments: json.RawMessage(fmt.Sprintf(`{"path":"file%d.txt"}`, i)),
+			Outcome:   "ok",
+		})
+	}
+	err = al.close()
+	if err != nil {
+		t.Fatalf("close() failed with %s", err)
+	}
+}
+
+func verifyAudit(t *testing.T, key ed25519.PublicKey, cnt []byte) *auditVerifier {
+	t.Helper()
+
+	av := &auditVerifier{key: key}
+	err := av.verify("audit.log", bytes.NewReader(cnt))
+	if err != nil {
+		t.Fatalf("verify() failed with %s", err)
+	}
+	return av
+}
+
+func TestAuditVerify(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed with %s", err)
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed with %s", err)
+	}
+
+	// The chain continues when the audit log is opened again.
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditRecords(t, auditFile, key, 0, 7)
+	writeAuditRecords(t, auditFile, key, 7, 2)
+
+	cnt, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) failed with %s", auditFile, err)
+	}
+	lines := strings.SplitAfter(string(cnt), "\n")
+	lines = lines[:len(lines)-1]
+
+	av := verifyAudit(t, pub, cnt)
+	if len(av.problems) > 0 {
+		t.Errorf("verify() got %v", av.problems)
+	}
+	// Checkpoints after 3 and 6 records, when closed after 7 records, after 9 records, and
+	// when closed after 9 records.
+	if av.records != 9 || av.checkpoints != 4 || av.seq != 13 || av.signed != 13 {
+		t.Errorf("verify() got %d records, %d checkpoints, seq %d, signed %d", av.records,
+			av.checkpoints, av.seq, av.signed)
+	}
+
+	cases := []struct {
+		change  func(lines []string) []string
+		key     ed25519.PublicKey
+		problem string
+	}{
+		{
+			change: func(lines []string) []string {
+				lines[2] = strings.Replace(lines[2], "file2.txt", "file0.txt", 1)
+				return lines
+			},
+			key:     pub,
+			problem: "records were modified",
+		},
+		{
+			change: func(lines []string) []string {
+				return append(lines[:5:5], lines[6:]...)
+			},
+			key:     pub,
+			problem: "records are missing",
+		},
+		{
+			change: func(lines []string) []string {
+				return append(lines[:5:5], lines[5], lines[5], lines[6])
+			},
+			key:     pub,
+			problem: "expected",
+		},
+		{
+			change: func(lines []string) []string {
+				return append(lines, `{"seq":14`)
+			},
+			key:     pub,
+			problem: "incomplete record",
+		},
+		{
+			change:  func(lines []string) []string { return lines },
+			key:     otherPub,
+			problem: "signed by a different key",
+		},
+	}
+
+	for _, c := range cases {
+		changed := c.change(append([]string{}, lines...))
+		av := verifyAudit(t, c.key, []byte(strings.Join(changed, "")))
+		if len(av.problems) == 0 {
+			t.Errorf("verify() did not detect %s", c.problem)
+		} else if !strings.Contains(av.problems[0], c.problem) {
+			t.Errorf("verify() got %s, want %s", av.problems[0], c.problem)
+		}
+	}
+}
+
+func TestAuditVerifyRotated(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	al, err := openAuditLog(auditFile, 512, 2, nil, 0)
+	if err != nil {
+		t.Fatalf("openAuditLog(%s) failed with %s", auditFile, err)
+	}
+	for i := 0; i < 10; i++ {
+		al.write(auditRecord{Tool: "list_roots", Outcome: "ok"})
+	}
+	al.close()
+
+	files := auditFiles(auditFile)
+	if len(files) != 3 || files[2] != auditFile {
+		t.Fatalf("auditFiles(%s) got %v", auditFile, files)
+	}
+
+	av := &auditVerifier{}
+	for _, file := range files {
+		err := av.verifyFile(file)
+		if err != nil {
+			t.Fatalf("verifyFile(%s) failed with %s", file, err)
+		}
+	}
+	if len(av.problems) > 0 {
+		t.Errorf("verify() got %v", av.problems)
+	}
+	if av.seq != 10 || av.first == 1 {
+		t.Errorf("verify() got sequence %d to %d", av.first, av.seq)
+	}
+}
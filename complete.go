@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const maxCompletions = 100
+
+var defaultIgnore = []string{".git", ".hg", ".svn", "node_modules"}
+
+// parseIgnore returns the patterns of names which are skipped when completing and suggesting
+// paths.
+func parseIgnore(patterns []string) ([]string, error) {
+	var ignore []string
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("ignore: %s: %s", pattern, err)
+		}
+		ignore = append(ignore, pattern)
+	}
+	return ignore, nil
+}
+
+// handleComplete completes the path argument of the explain_file prompt.
+func (ft fileTools) handleComplete(ctx context.Context,
+	req *mcp.CompleteRequest) (*mcp.CompleteResult, error) {
+
+	slog.Info("complete", "ref", req.Params.Ref, "argument", req.Params.Argument)
+
+	err := ft.roots.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	if ref := req.Params.Ref; ref != nil && ref.Type == "ref/prompt" &&
+		ref.Name == explainFilePrompt && req.Params.Argument.Name == "path" {
+
+		values, err = ft.completePath(ctx, req.Params.Argument.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	total := len(values)
+	if total > maxCompletions {
+		values = values[:maxCompletions]
+	}
+	if values == nil {
+		values = []string{}
+	}
+
+	return &mcp.CompleteResult{
+		Completion: mcp.CompletionResultDetails{
+			Values:  values,
+			Total:   total,
+			HasMore: total > len(values),
+		},
+	}, nil
+}
+
+func (ft fileTools) ignored(name string) bool {
+	return ignored(ft.ignore, name)
+}
+
+// ignored returns true if name matches one of patterns.
+func ignored(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// completePath returns the paths in the directory named by value, which is relative to the
+// current directory, which match the last element of value and which are visible to the
+// session: first those with it as a prefix, then those which contain it as a
+// case-insensitive subsequence. Directories have a trailing slash.
+func (ft fileTools) completePath(ctx context.Context, value string) ([]string, error) {
+	dir := "."
+	prefix := value
+	if idx := strings.LastIndex(value, "/"); idx >= 0 {
+		dir = value[:idx]
+		prefix = value[idx+1:]
+		if dir == "" {
+			return nil, nil
+		}
+	}
+
+	treeDir, err := ft.resolvePath(dir)
+	if err != nil {
+		return nil, nil
+	}
+	lst, err := fs.ReadDir(ft.fs, treeDir)
+	if err != nil {
+		return nil, nil
+	}
+
+	realDir := ft.realPath(treeDir)
+	var prefixed, fuzzy []string
+	for _, de := range lst {
+		name := de.Name()
+		if ft.ignored(name) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) ||
+			!ft.visible(path.Join(treeDir, name)) || !ft.visible(path.Join(realDir, name)) {
+
+			continue
+		}
+
+		completion := name
+		if dir != "." {
+			completion = path.Join(dir, name)
+		}
+		if de.IsDir() {
+			completion += "/"
+		}
+
+		if strings.HasPrefix(name, prefix) {
+			prefixed = append(prefixed, completion)
+		} else if fuzzyMatch(strings.ToLower(prefix), strings.ToLower(name)) {
+			fuzzy = append(fuzzy, completion)
+		}
+	}
+
+	return append(prefixed, fuzzy...), nil
+}
+
+// fuzzyMatch returns true if the characters of pattern appear in order in s.
+func fuzzyMatch(pattern, s string) bool {
+	for _, r := range pattern {
+		idx := strings.IndexRune(s, r)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(string(r)):]
+	}
+	return true
+}
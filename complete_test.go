@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestCompletePath(t *testing.T) {
+	tempDir := t.TempDir()
+
+	mustWriteFile(t, filepath.Join(tempDir, "main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "makefile"), []byte("all:"))
+	mustWriteFile(t, filepath.Join(tempDir, "readme.md"), []byte("readme"))
+	mustWriteFile(t, filepath.Join(tempDir, ".hidden"), []byte("hidden"))
+	mustWriteFile(t, filepath.Join(tempDir, "src", "filetools.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "src", "files.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "src", "lib", "util.go"), []byte("package lib"))
+	mustWriteFile(t, filepath.Join(tempDir, ".git", "HEAD"), []byte("ref"))
+	mustWriteFile(t, filepath.Join(tempDir, "node_modules", "x.js"), []byte("x"))
+
+	cases := []struct {
+		value       string
+		completions []string
+	}{
+		{value: "", completions: []string{"main.go", "makefile", "readme.md", "src/"}},
+		{value: "ma", completions: []string{"main.go", "makefile"}},
+		{value: "mgo", completions: []string{"main.go"}},
+		{value: "RDM", completions: []string{"readme.md"}},
+		{value: ".", completions: []string{".hidden", "main.go", "readme.md"}},
+		{value: ".g", completions: []string{"main.go"}},
+		{value: "node", completions: nil},
+		{value: "src/", completions: []string{"src/files.go", "src/filetools.go", "src/lib/"}},
+		{value: "src/files", completions: []string{"src/files.go", "src/filetools.go"}},
+		{value: "src/l", completions: []string{"src/lib/", "src/files.go", "src/filetools.go"}},
+		{value: "src/lib/u", completions: []string{"src/lib/util.go"}},
+		{value: "missing/", completions: nil},
+		{value: "/etc/pa", completions: nil},
+		{value: "../", completions: nil},
+		{value: "xyz", completions: nil},
+	}
+
+	ft := fileTools{fs: os.DirFS(tempDir), ignore: defaultIgnore}
+	ctx := context.Background()
+
+	for _, c := range cases {
+		completions, err := ft.completePath(ctx, c.value)
+		if err != nil {
+			t.Errorf("completePath(%s) failed with %s", c.value, err)
+		} else if !reflect.DeepEqual(completions, c.completions) {
+			t.Errorf("completePath(%s) got %v, want %v", c.value, completions, c.completions)
+		}
+	}
+	// Paths are completed relative to the current directory.
+	ft.cwd = &workingDir{dir: "src"}
+	for _, c := range []struct {
+		value       string
+		completions []string
+	}{
+		{value: "fi", completions: []string{"files.go", "filetools.go"}},
+		{value: "lib/", completions: []string{"lib/util.go"}},
+		{value: "../ma", completions: []string{"../main.go", "../makefile"}},
+		{value: "../../", completions: nil},
+	} {
+		completions, err := ft.completePath(ctx, c.value)
+		if err != nil {
+			t.Errorf("completePath(%s) in src failed with %s", c.value, err)
+		} else if !reflect.DeepEqual(completions, c.completions) {
+			t.Errorf("completePath(%s) in src got %v, want %v", c.value, completions,
+				c.completions)
+		}
+	}
+}
+
+func TestHandleComplete(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for i := range maxCompletions + 10 {
+		mustWriteFile(t, filepath.Join(tempDir, fmt.Sprintf("file%03d.txt", i)), []byte{})
+	}
+
+	ft := fileTools{fs: os.DirFS(tempDir)}
+	ctx := context.Background()
+
+	prompt := &mcp.CompleteReference{Type: "ref/prompt", Name: explainFilePrompt}
+	cases := []struct {
+		ref     *mcp.CompleteReference
+		name    string
+		value   string
+		count   int
+		total   int
+		hasMore bool
+	}{
+		{ref: prompt, name: "path", value: "file00", count: 20, total: 20},
+		{ref: prompt, name: "path", value: "file", count: maxCompletions,
+			total: maxCompletions + 10, hasMore: true},
+		{ref: prompt, name: "path", value: "nothing", count: 0, total: 0},
+		{ref: prompt, name: "other", value: "file", count: 0, total: 0},
+		{ref: &mcp.CompleteReference{Type: "ref/prompt", Name: "other"}, name: "path",
+			value: "file", count: 0, total: 0},
+		{ref: &mcp.CompleteReference{Type: "ref/resource", URI: "file:///{path}"}, name: "path",
+			value: "file", count: 0, total: 0},
+	}
+
+	for _, c := range cases {
+		res, err := ft.handleComplete(ctx, &mcp.CompleteRequest{
+			Params: &mcp.CompleteParams{
+				Argument: mcp.CompleteParamsArgument{Name: c.name, Value: c.value},
+				Ref:      c.ref,
+			},
+		})
+		if err != nil {
+			t.Errorf("handleComplete(%s, %s) failed with %s", c.name, c.value, err)
+			continue
+		}
+
+		cmpl := res.Completion
+		if len(cmpl.Values) != c.count || cmpl.Total != c.total || cmpl.HasMore != c.hasMore {
+			t.Errorf("handleComplete(%s, %s) got %d values, total %d, hasMore %v, want %d, %d, %v",
+				c.name, c.value, len(cmpl.Values), cmpl.Total, cmpl.HasMore, c.count, c.total,
+				c.hasMore)
+		}
+		if cmpl.Values == nil {
+			t.Errorf("handleComplete(%s, %s) got nil values", c.name, c.value)
+		}
+	}
+}
+
+func TestParseIgnore(t *testing.T) {
+	cases := []struct {
+		patterns []string
+		ignore   []string
+		fail     bool
+	}{
+		{patterns: nil, ignore: nil},
+		{patterns: []string{".git", " *.o ", ""}, ignore: []string{".git", "*.o"}},
+		{patterns: []string{"[a-"}, fail: true},
+	}
+
+	for _, c := range cases {
+		ignore, err := parseIgnore(c.patterns)
+		if c.fail {
+			if err == nil {
+				t.Errorf("parseIgnore(%v) did not fail", c.patterns)
+			}
+		} else if err != nil {
+			t.Errorf("parseIgnore(%v) failed with %s", c.patterns, err)
+		} else if !reflect.DeepEqual(ignore, c.ignore) {
+			t.Errorf("parseIgnore(%v) got %v, want %v", c.patterns, ignore, c.ignore)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// stringList is a list of strings: a comma separated flag, and either a string, which is
+// also comma separated, or a list of strings in a configuration file.
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(s string) error {
+	*sl = nil
+	if s != "" {
+		*sl = strings.Split(s, ",")
+	}
+	return nil
+}
+
+func (sl *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		return sl.Set(s)
+	}
+	return json.Unmarshal(b, (*[]string)(sl))
+}
+
+// duration is a time.Duration which is a string, such as "10s", in a configuration file.
+type duration time.Duration
+
+func (d *duration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *duration) Set(s string) error {
+	td, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(td)
+	return nil
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// config is the configuration of the server. Each setting is both a flag and a field of the
+// JSON configuration file; flags override the configuration file.
+type config struct {
+	Config string `json:"-"`
+
+	Log         bool   `json:"log"`
+	LogFile     string `json:"logfile"`
+	LogProto    string `json:"logproto"`
+	LogProtoDir string `json:"logprotodir"`
+
+	Stdio      bool       `json:"stdio"`
+	SSE        bool       `json:"sse"`
+	HTTP       bool       `json:"http"`
+	Addr       string     `json:"addr"`
+	Unix       string     `json:"unix"`
+	UnixMode   string     `json:"unixmode"`
+	Cert       string     `json:"cert"`
+	Key        string     `json:"key"`
+	SelfSigned bool       `json:"selfsigned"`
+	Hostnames  stringList `json:"hostnames"`
+
+	Tokens   string          `json:"tokens"`
+	ClientCA string          `json:"client-ca"`
+	JWKS     string          `json:"jwks"`
+	Issuer   string          `json:"issuer"`
+	Resource string          `json:"resource"`
+	Access   string          `json:"access"`
+	Policy   json.RawMessage `json:"policy"`
+	Confirm  stringList      `json:"confirm"`
+	Limits   json.RawMessage `json:"limits"`
+
+	Deny          stringList `json:"deny"`
+	NoDefaultDeny bool       `json:"nodefaultdeny"`
+	Redact        stringList `json:"redact"`
+	NoRedact      bool       `json:"noredact"`
+	RedactEntropy bool       `json:"redactentropy"`
+
+	Ignore stringList `json:"ignore"`
+
+	Symlinks    string     `json:"symlinks"`
+	SymlinkDirs stringList `json:"symlinkdirs"`
+
+	MaxBytes int `json:"maxbytes"`
+	MaxItems int `json:"maxitems"`
+
+	Audit           string `json:"audit"`
+	AuditMaxSize    int64  `json:"auditmaxsize"`
+	AuditKeep       int    `json:"auditkeep"`
+	AuditKey        string `json:"auditkey"`
+	AuditCheckpoint uint64 `json:"auditcheckpoint"`
+
+	State    string     `json:"state"`
+	Shutdown duration   `json:"shutdown"`
+	Roots    stringList `json:"roots"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Addr:            ":8443",
+		UnixMode:        "0600",
+		Shutdown:        duration(10 * time.Second),
+		AuditMaxSize:    100,
+		AuditKeep:       5,
+		AuditCheckpoint: 1000,
+		MaxBytes:        256 * 1024,
+		MaxItems:        1000,
+		Ignore:          slices.Clone(defaultIgnore),
+	}
+}
+
+func (cfg *config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Config, "config", "", "JSON configuration file, reloaded on SIGHUP")
+	fs.BoolVar(&cfg.Log, "log", cfg.Log, "enable logging")
+	fs.StringVar(&cfg.LogFile, "logfile", cfg.LogFile, "log file path")
+	fs.StringVar(&cfg.LogProto, "logproto", cfg.LogProto, "protocol log file path")
+	fs.StringVar(&cfg.LogProtoDir, "logprotodir", cfg.LogProtoDir, "directory for a protocol log file per session")
+	fs.BoolVar(&cfg.Stdio, "stdio", cfg.Stdio, "use stdio transport")
+	fs.BoolVar(&cfg.SSE, "sse", cfg.SSE, "use SSE transport at /sse")
+	fs.BoolVar(&cfg.HTTP, "http", cfg.HTTP, "use streaming HTTP transport at /mcp")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "HTTPS server address, or HTTP if loopback only and no -cert or -selfsigned (empty for none)")
+	fs.StringVar(&cfg.Unix, "unix", cfg.Unix, "also serve -sse and -http over HTTP on a Unix domain socket at this path")
+	fs.StringVar(&cfg.UnixMode, "unixmode", cfg.UnixMode, "file mode of the -unix socket; besides -tokens or -jwks, it is the only control of who may connect")
+	fs.StringVar(&cfg.Cert, "cert", cfg.Cert, "TLS certificate file (required for -sse or -http on a non-loopback -addr, unless -selfsigned)")
+	fs.StringVar(&cfg.Key, "key", cfg.Key, "TLS key file (required for -sse or -http on a non-loopback -addr, unless -selfsigned)")
+	fs.BoolVar(&cfg.SelfSigned, "selfsigned", cfg.SelfSigned, "use a certificate signed by a local CA kept in the state directory")
+	fs.Var(&cfg.Hostnames, "hostnames", "comma separated hostnames and addresses, besides localhost, for -selfsigned")
+	fs.StringVar(&cfg.Tokens, "tokens", cfg.Tokens, "file of name:token bearer tokens for -sse or -http (also $FILEMCP_TOKENS)")
+	fs.StringVar(&cfg.ClientCA, "client-ca", cfg.ClientCA, "require -sse or -http clients to have a certificate signed by a CA in this file")
+	fs.StringVar(&cfg.JWKS, "jwks", cfg.JWKS, "validate JWT access tokens for -sse or -http with the keys in this JWKS file or URL")
+	fs.StringVar(&cfg.Issuer, "issuer", cfg.Issuer, "issuer of JWT access tokens (with -jwks)")
+	fs.StringVar(&cfg.Resource, "resource", cfg.Resource, "resource URL which JWT access tokens must have as their audience (with -jwks)")
+	fs.StringVar(&cfg.Access, "access", cfg.Access, "JSON file of the tools and paths each identity may use")
+	fs.Var(&cfg.Ignore, "ignore", "comma separated patterns of names, such as .git or node_modules, which are skipped when completing and suggesting paths")
+	fs.StringVar(&cfg.Symlinks, "symlinks", cfg.Symlinks, "which symbolic links are followed: inroot (to the same root), never, or allow (also to -symlinkdirs)")
+	fs.Var(&cfg.SymlinkDirs, "symlinkdirs", "comma separated directories, outside of the roots, which symbolic links may lead to (with -symlinks allow)")
+	fs.IntVar(&cfg.MaxBytes, "maxbytes", cfg.MaxBytes, "maximum bytes of file content in a response; the rest can be requested by offset (0 for no limit)")
+	fs.IntVar(&cfg.MaxItems, "maxitems", cfg.MaxItems, "maximum directory entries or search matches in a response; the rest can be requested by offset (0 for no limit)")
+	fs.StringVar(&cfg.Audit, "audit", cfg.Audit, "JSON-lines audit log file of tool calls")
+	fs.Int64Var(&cfg.AuditMaxSize, "auditmaxsize", cfg.AuditMaxSize, "size in MB at which the audit log is rotated (0 for never)")
+	fs.IntVar(&cfg.AuditKeep, "auditkeep", cfg.AuditKeep, "number of rotated audit logs to keep")
+	fs.StringVar(&cfg.AuditKey, "auditkey", cfg.AuditKey, "ed25519 private key file which signs audit log checkpoints (created, with a .pub public key, if missing)")
+	fs.Uint64Var(&cfg.AuditCheckpoint, "auditcheckpoint", cfg.AuditCheckpoint, "number of audit records between signed checkpoints (with -auditkey)")
+	fs.StringVar(&cfg.State, "state", cfg.State, "state directory (default filemcp in the user config directory)")
+	fs.Var(&cfg.Shutdown, "shutdown", "how long to wait for tool calls to finish when shutting down")
+	fs.Var(&cfg.Confirm, "confirm", "comma separated tools which require the user to confirm each call")
+	fs.Var(&cfg.Deny, "deny", "comma separated patterns of paths, such as .ssh or secrets/*.key, which are hidden and blocked")
+	fs.Var(&cfg.Redact, "redact", "comma separated regular expressions of secrets to redact from file contents (the first group, if any, is redacted)")
+	fs.BoolVar(&cfg.NoRedact, "noredact", cfg.NoRedact, "don't redact secrets, such as AWS keys, private keys, JWTs, and passwords, found by the built-in detectors")
+	fs.BoolVar(&cfg.RedactEntropy, "redactentropy", cfg.RedactEntropy, "also redact long random looking strings, such as keys without a known format; identifiers and hashes may be redacted too")
+	fs.BoolVar(&cfg.NoDefaultDeny, "nodefaultdeny", cfg.NoDefaultDeny, "don't hide and block sensitive paths, such as .ssh, .aws, .gnupg, browser profiles, and .env files")
+}
+
+// loadConfig parses args into a configuration using fs. If there is a -config file, it is
+// loaded, and then args are parsed again, so that flags override it. Arguments, which are the
+// roots, also override the roots of the configuration file.
+func loadConfig(fs *flag.FlagSet, args []string) (*config, error) {
+	cfg := defaultConfig()
+	cfg.flags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Config != "" {
+		cnt, err := os.ReadFile(cfg.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(cnt))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", cfg.Config, err)
+		}
+
+		err = fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fs.NArg() > 0 {
+		cfg.Roots = fs.Args()
+	}
+	if cfg.Access != "" && len(cfg.Policy) > 0 {
+		return nil, fmt.Errorf("access and policy may not both be used")
+	}
+	return cfg, nil
+}
+
+// accessPolicy returns the access policy of the configuration, from either the -access file
+// or the policy of the configuration file.
+func (cfg *config) accessPolicy() (accessPolicy, error) {
+	if cfg.Access != "" {
+		return loadAccessPolicy(cfg.Access)
+	} else if len(cfg.Policy) > 0 {
+		return parseAccessPolicy(cfg.Policy)
+	}
+	return nil, nil
+}
+
+// restartRequired returns the settings which are different in cfg and other and which can
+// only be changed by restarting the server.
+func (cfg *config) restartRequired(other *config) []string {
+	var settings []string
+
+	a := reflect.ValueOf(cfg).Elem()
+	b := reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		sf := a.Type().Field(i)
+		switch sf.Name {
+		case "Config", "Tokens", "Access", "Policy", "Confirm", "Limits":
+			// These settings are reloaded.
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			settings = append(settings, sf.Tag.Get("json"))
+		}
+	}
+	return settings
+}
+
+// reloadConfig loads the configuration again, and applies the settings which can be changed
+// without restarting the server: the access policy, the tools which require confirmation,
+// the rate limits, and, if bearer tokens are required, the bearer tokens. It returns the
+// configuration which is in effect; if the configuration can't be loaded, that is the
+// current configuration.
+func reloadConfig(sf *serverFactory, cfg *config, tokensRequired bool) *config {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	newCfg, err := loadConfig(fs, os.Args[1:])
+	if err != nil {
+		slog.Error("reload configuration", "config", cfg.Config, "error", err)
+		return cfg
+	}
+
+	policy, err := newCfg.accessPolicy()
+	if err != nil {
+		slog.Error("reload configuration", "config", cfg.Config, "error", err)
+		return cfg
+	}
+	tokens, err := loadTokens(newCfg.Tokens)
+	if err != nil {
+		slog.Error("reload configuration", "config", cfg.Config, "error", err)
+		return cfg
+	}
+	limits, err := parseLimitPolicy(newCfg.Limits)
+	if err != nil {
+		slog.Error("reload configuration", "config", cfg.Config, "error", err)
+		return cfg
+	}
+	confirm, err := parseConfirm(newCfg.Confirm)
+	if err != nil {
+		slog.Error("reload configuration", "config", cfg.Config, "error", err)
+		return cfg
+	}
+
+	if settings := cfg.restartRequired(newCfg); len(settings) > 0 {
+		slog.Warn("reload configuration: restart required to change settings", "settings",
+			strings.Join(settings, ","))
+	}
+	if !tokensRequired && len(tokens) > 0 {
+		slog.Warn("reload configuration: restart required to require bearer tokens")
+		tokens = nil
+	}
+
+	sf.reload(policy, confirm, tokens)
+	sf.limiter.setPolicy(limits)
+
+	updated := *cfg
+	updated.Tokens = newCfg.Tokens
+	updated.Access = newCfg.Access
+	updated.Policy = newCfg.Policy
+	updated.Confirm = newCfg.Confirm
+	updated.Limits = newCfg.Limits
+	return &updated
+}
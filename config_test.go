@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	cfgFile := filepath.Join(tempDir, "config.json")
+	mustWriteFile(t, cfgFile, []byte(`{
+	"http": true,
+	"addr": "127.0.0.1:9000",
+	"hostnames": "a.example.com,b.example.com",
+	"confirm": ["read_file"],
+	"shutdown": "5s",
+	"policy": {"alice": {"tools": ["read_file"]}},
+	"roots": ["~"]
+}`))
+	badFile := filepath.Join(tempDir, "bad.json")
+	mustWriteFile(t, badFile, []byte(`{"unknown": true}`))
+	bothFile := filepath.Join(tempDir, "both.json")
+	mustWriteFile(t, bothFile, []byte(`{"access": "access.json", "policy": {}}`))
+
+	cases := []struct {
+		args []string
+		cfg  func(cfg *config)
+		fail bool
+	}{
+		{
+			args: []string{"-stdio", "/tmp"},
+			cfg: func(cfg *config) {
+				cfg.Stdio = true
+				cfg.Roots = stringList{"/tmp"}
+			},
+		},
+		{
+			args: []string{"-config", cfgFile},
+			cfg: func(cfg *config) {
+				cfg.Config = cfgFile
+				cfg.HTTP = true
+				cfg.Addr = "127.0.0.1:9000"
+				cfg.Hostnames = stringList{"a.example.com", "b.example.com"}
+				cfg.Confirm = stringList{"read_file"}
+				cfg.Shutdown = duration(5 * time.Second)
+				cfg.Policy = []byte(`{"alice": {"tools": ["read_file"]}}`)
+				cfg.Roots = stringList{"~"}
+			},
+		},
+		{
+			args: []string{"-config", cfgFile, "-addr", "127.0.0.1:9999", "-confirm", "",
+				"/tmp", "/home"},
+			cfg: func(cfg *config) {
+				cfg.Config = cfgFile
+				cfg.HTTP = true
+				cfg.Addr = "127.0.0.1:9999"
+				cfg.Hostnames = stringList{"a.example.com", "b.example.com"}
+				cfg.Shutdown = duration(5 * time.Second)
+				cfg.Policy = []byte(`{"alice": {"tools": ["read_file"]}}`)
+				cfg.Roots = stringList{"/tmp", "/home"}
+			},
+		},
+		{args: []string{"-config", badFile}, fail: true},
+		{args: []string{"-config", bothFile}, fail: true},
+		{args: []string{"-config", filepath.Join(tempDir, "missing.json")}, fail: true},
+		{args: []string{"-shutdown", "forever"}, fail: true},
+	}
+
+	for _, c := range cases {
+		fs := flag.NewFlagSet("filemcp", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		cfg, err := loadConfig(fs, c.args)
+		if c.fail {
+			if err == nil {
+				t.Errorf("loadConfig(%v) did not fail", c.args)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("loadConfig(%v) failed with %s", c.args, err)
+			continue
+		}
+
+		want := defaultConfig()
+		c.cfg(want)
+		if len(cfg.Policy) > 0 {
+			policy, err := cfg.accessPolicy()
+			if err != nil {
+				t.Errorf("accessPolicy() failed with %s", err)
+			} else if !policy.lookup("alice").allowTool("read_file") {
+				t.Errorf("accessPolicy() got %v", policy)
+			}
+			cfg.Policy = want.Policy
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("loadConfig(%v) got %+v, want %+v", c.args, cfg, want)
+		}
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	cfg := defaultConfig()
+	other := defaultConfig()
+	other.Confirm = stringList{"read_file"}
+	other.Access = "access.json"
+	other.Tokens = "tokens.txt"
+	other.Limits = []byte(`{"session": {"*": {"rate": 1}}}`)
+	if settings := cfg.restartRequired(other); len(settings) != 0 {
+		t.Errorf("restartRequired() got %v, want none", settings)
+	}
+
+	other.Addr = "127.0.0.1:9000"
+	other.Roots = stringList{"/tmp"}
+	settings := cfg.restartRequired(other)
+	if !reflect.DeepEqual(settings, []string{"addr", "roots"}) {
+		t.Errorf("restartRequired() got %v, want addr and roots", settings)
+	}
+}
+
+func TestReload(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "src", "main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "docs", "readme.md"), []byte("readme"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		policy: accessPolicy{
+			"alice": {Tools: []string{"read_file"}, Paths: []string{"src"}},
+		},
+	}
+
+	changed := make(chan struct{}, 1)
+	opts := &mcp.ClientOptions{
+		Capabilities: &mcp.ClientCapabilities{},
+		ToolListChangedHandler: func(ctx context.Context, req *mcp.ToolListChangedRequest) {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		},
+	}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{identity: "alice"}), opts)
+
+	listTools := func() []string {
+		t.Helper()
+
+		res, err := cs.ListTools(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("ListTools() failed with %s", err)
+		}
+		var tools []string
+		for _, tool := range res.Tools {
+			tools = append(tools, tool.Name)
+		}
+		slices.Sort(tools)
+		return tools
+	}
+
+	if tools := listTools(); !reflect.DeepEqual(tools, []string{"read_file"}) {
+		t.Errorf("ListTools() got %v, want read_file", tools)
+	}
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "docs/readme.md"}); !res.IsError {
+		t.Errorf("read_file(docs/readme.md) did not fail")
+	}
+
+	// Wait for the session to be tracked, which happens once it is initialized.
+	for i := 0; ; i++ {
+		sf.mu.Lock()
+		n := len(sf.sessions)
+		sf.mu.Unlock()
+		if n > 0 {
+			break
+		} else if i == 100 {
+			t.Fatalf("session was not tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	sf.reload(accessPolicy{
+		"alice": {Tools: []string{"read_file", "list_directory"}},
+	}, []string{"list_directory"}, nil)
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("reload() did not send tools/list_changed")
+	}
+	if tools := listTools(); !reflect.DeepEqual(tools, []string{"list_directory", "read_file"}) {
+		t.Errorf("ListTools() got %v, want list_directory and read_file", tools)
+	}
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "docs/readme.md"}); res.IsError {
+		t.Errorf("read_file(docs/readme.md) failed with %v", res.Content)
+	}
+	if res := callTool(t, cs, "list_directory", map[string]any{"path": "."}); !res.IsError {
+		t.Errorf("list_directory(.) did not require confirmation")
+	}
+
+	sf.reload(accessPolicy{}, nil, nil)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("reload() did not send tools/list_changed")
+	}
+	if tools := listTools(); len(tools) != 0 {
+		t.Errorf("ListTools() got %v, want none", tools)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// parseConfirm returns the tools which require confirmation. Each tool must be the name of
+// one of the file tools.
+func parseConfirm(tools []string) ([]string, error) {
+	var names []string
+	for _, st := range (fileTools{}).tools() {
+		names = append(names, st.name)
+	}
+
+	var confirm []string
+	for _, tool := range tools {
+		tool = strings.TrimSpace(tool)
+		if tool == "" {
+			continue
+		} else if !slices.Contains(names, tool) {
+			return nil, fmt.Errorf("confirm: %s: unknown tool", tool)
+		}
+		confirm = append(confirm, tool)
+	}
+	return confirm, nil
+}
+
+// confirmTools returns middleware which asks the user, using elicitation, to confirm each
+// call of one of the tools returned by tools before it is made. Calls are refused if the
+// client does not support elicitation or the user does not confirm them. callPaths returns
+// the current directory of the session, and the path argument of a call resolved against
+// it, which are shown to the user along with the arguments.
+func confirmTools(tools func() []string,
+	callPaths func(args json.RawMessage) (string, string)) mcp.Middleware {
+
+	return func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			ctr, ok := req.(*mcp.CallToolRequest)
+			if !ok || method != "tools/call" || !slices.Contains(tools(), ctr.Params.Name) {
+				return next(ctx, method, req)
+			}
+
+			cwd, p := callPaths(ctr.Params.Arguments)
+			err := confirmCall(ctx, ctr.Session, ctr.Params.Name, ctr.Params.Arguments, cwd, p)
+			if err != nil {
+				slog.Info("call not confirmed", "tool", ctr.Params.Name, "error", err)
+				auditError(ctx, err)
+				return newToolError(err, ctr.Params.Arguments).result(), nil
+			}
+			return next(ctx, method, req)
+		}
+	}
+}
+
+var errNotConfirmed = errors.New("not confirmed by user")
+
+func confirmCall(ctx context.Context, ss *mcp.ServerSession, tool string,
+	args json.RawMessage, cwd, p string) error {
+
+	params := ss.InitializeParams()
+	if params == nil || params.Capabilities == nil || params.Capabilities.Elicitation == nil {
+		return fmt.Errorf("%s: %w: client does not support elicitation", tool, errNotConfirmed)
+	}
+
+	var buf bytes.Buffer
+	if json.Indent(&buf, args, "", "  ") != nil {
+		buf.Reset()
+		buf.Write(args)
+	}
+	if p != "" {
+		fmt.Fprintf(&buf, "\n\nPath: %s", p)
+	} else if cwd != "." {
+		fmt.Fprintf(&buf, "\n\nCurrent directory: %s", cwd)
+	}
+
+	res, err := ss.Elicit(ctx, &mcp.ElicitParams{
+		Message: fmt.Sprintf("Allow %s with these arguments?\n\n%s", tool, buf.String()),
+		RequestedSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"confirm": map[string]any{
+					"type":        "boolean",
+					"description": "Allow " + tool,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%s: confirmation failed: %w", tool, err)
+	}
+
+	if res.Action != "accept" || res.Content["confirm"] != true {
+		return fmt.Errorf("%s: %w", tool, errNotConfirmed)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestConfirmTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("content"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		confirm:     []string{"read_file"},
+	}
+
+	cases := []struct {
+		action   string
+		confirm  bool
+		noElicit bool
+		tool     string
+		fail     bool
+	}{
+		{action: "accept", confirm: true, tool: "read_file"},
+		{action: "accept", confirm: false, tool: "read_file", fail: true},
+		{action: "decline", tool: "read_file", fail: true},
+		{action: "cancel", tool: "read_file", fail: true},
+		{noElicit: true, tool: "read_file", fail: true},
+		{action: "decline", tool: "get_file_info"},
+		{noElicit: true, tool: "get_file_info"},
+	}
+
+	for _, c := range cases {
+		var messages []string
+		opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+		if !c.noElicit {
+			opts.ElicitationHandler = func(ctx context.Context,
+				req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+
+				messages = append(messages, req.Params.Message)
+				res := &mcp.ElicitResult{Action: c.action}
+				if c.action == "accept" {
+					res.Content = map[string]any{"confirm": c.confirm}
+				}
+				return res, nil
+			}
+		}
+
+		_, cs := connectClient(t, sf.newServer(sessionInfo{transport: "test"}), opts)
+		res := callTool(t, cs, c.tool, map[string]any{"path": "file.txt"})
+		if res.IsError {
+			if !c.fail {
+				t.Errorf("%s(%s) failed with %v", c.tool, c.action, res.Content)
+			} else if out, _ := res.StructuredContent.(map[string]any); out["code"] !=
+				"not_confirmed" {
+
+				t.Errorf("%s(%s) got %v, want not_confirmed", c.tool, c.action,
+					res.StructuredContent)
+			}
+		} else if c.fail {
+			t.Errorf("%s(%s) did not fail", c.tool, c.action)
+		}
+
+		if c.tool == "read_file" && !c.noElicit {
+			if len(messages) != 1 || !strings.Contains(messages[0], `"path": "file.txt"`) {
+				t.Errorf("%s(%s) got elicitation messages %v", c.tool, c.action, messages)
+			}
+		} else if len(messages) != 0 {
+			t.Errorf("%s(%s) got unexpected elicitation messages %v", c.tool, c.action,
+				messages)
+		}
+	}
+	// The prompt shows the path which the call uses, after cd.
+	mustWriteFile(t, filepath.Join(tempDir, "dir", "file.txt"), []byte("content"))
+	var messages []string
+	opts := &mcp.ClientOptions{
+		Capabilities: &mcp.ClientCapabilities{},
+		ElicitationHandler: func(ctx context.Context,
+			req *mcp.ElicitRequest) (*mcp.ElicitResult, error) {
+
+			messages = append(messages, req.Params.Message)
+			return &mcp.ElicitResult{Action: "accept",
+				Content: map[string]any{"confirm": true}}, nil
+		},
+	}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{transport: "test"}), opts)
+	if res := callTool(t, cs, "cd", map[string]any{"path": "dir"}); res.IsError {
+		t.Fatalf("cd(dir) failed with %v", res.Content)
+	}
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "file.txt"}); res.IsError {
+		t.Errorf("read_file(file.txt) failed with %v", res.Content)
+	}
+	if len(messages) != 1 || !strings.Contains(messages[0], "Path: dir/file.txt") {
+		t.Errorf("read_file(file.txt) got elicitation messages %v", messages)
+	}
+}
+
+func TestParseConfirm(t *testing.T) {
+	cases := []struct {
+		tools   []string
+		confirm []string
+		fail    bool
+	}{
+		{},
+		{tools: []string{"read_file"}, confirm: []string{"read_file"}},
+		{tools: []string{"read_file", " search_files"},
+			confirm: []string{"read_file", "search_files"}},
+		{tools: []string{" read_file ", "", "cd"}, confirm: []string{"read_file", "cd"}},
+		{tools: []string{"read_flie"}, fail: true},
+		{tools: []string{"read_file", "write_file"}, fail: true},
+	}
+
+	for _, c := range cases {
+		confirm, err := parseConfirm(c.tools)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("parseConfirm(%v) failed with %s", c.tools, err)
+			}
+		} else if c.fail {
+			t.Errorf("parseConfirm(%v) did not fail", c.tools)
+		} else if !reflect.DeepEqual(confirm, c.confirm) {
+			t.Errorf("parseConfirm(%v) got %v, want %v", c.tools, confirm, c.confirm)
+		}
+	}
+}
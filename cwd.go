@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// workingDir is the current directory of a session, relative to the top of the tree.
+// Relative paths are resolved against it.
+type workingDir struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// get returns the current directory; a nil workingDir is always at the top of the tree.
+func (wd *workingDir) get() string {
+	if wd == nil {
+		return "."
+	}
+
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if wd.dir == "" {
+		return "."
+	}
+	return wd.dir
+}
+
+func (wd *workingDir) set(dir string) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	wd.dir = dir
+}
+
+// cwdRelative returns p, which is relative to the top of the tree, relative to the current
+// directory; it is empty if the current directory is the top of the tree.
+func (ft fileTools) cwdRelative(p string) string {
+	cwd := ft.cwd.get()
+	if cwd == "." {
+		return ""
+	}
+
+	rel, err := filepath.Rel(filepath.FromSlash(cwd), filepath.FromSlash(p))
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+type cdInput struct {
+	Path string `json:"path,omitempty" jsonschema:"directory to change to, relative to the current directory (empty for the root directory)"`
+}
+
+type cdOutput struct {
+	Cwd string `json:"cwd" jsonschema:"the current directory, relative to the root directory"`
+}
+
+func (ft fileTools) handleCd(ctx context.Context, req *mcp.CallToolRequest,
+	args cdInput) (*mcp.CallToolResult, cdOutput, error) {
+
+	slog.Info("cd", "args", args)
+
+	dir := "."
+	if args.Path != "" {
+		var err error
+		dir, err = ft.resolvePath(args.Path)
+		if err != nil {
+			return nil, cdOutput{}, err
+		}
+	}
+
+	err := ft.checkVisible(ctx, dir)
+	if err != nil {
+		return nil, cdOutput{}, err
+	}
+
+	fi, err := fs.Stat(ft.fs, dir)
+	if err != nil {
+		return nil, cdOutput{}, ft.notFound(ctx, dir, err, ft.visible)
+	} else if !fi.IsDir() {
+		return nil, cdOutput{}, &fs.PathError{Op: "cd", Path: dir, Err: syscall.ENOTDIR}
+	}
+
+	ft.cwd.set(dir)
+	return nil, cdOutput{
+		Cwd: dir,
+	}, nil
+}
+
+type pwdInput struct{}
+
+type pwdOutput struct {
+	Cwd string `json:"cwd" jsonschema:"the current directory, relative to the root directory"`
+}
+
+func (ft fileTools) handlePwd(ctx context.Context, req *mcp.CallToolRequest,
+	args pwdInput) (*mcp.CallToolResult, pwdOutput, error) {
+
+	slog.Info("pwd")
+
+	return nil, pwdOutput{
+		Cwd: ft.cwd.get(),
+	}, nil
+}
+
+// joinCwd returns p, which is relative to the current directory, relative to the top of the
+// tree.
+func (ft fileTools) joinCwd(p string) string {
+	return path.Join(ft.cwd.get(), p)
+}
+
+// callPaths returns the current directory of the session, and the path argument of a tool
+// call, args, relative to the top of the tree; the path is empty if the call doesn't have one
+// or it can't be resolved.
+func (ft fileTools) callPaths(args json.RawMessage) (string, string) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	json.Unmarshal(args, &params)
+	if params.Path == "" {
+		return ft.cwd.get(), ""
+	}
+
+	p, err := ft.resolvePath(params.Path)
+	if err != nil {
+		return ft.cwd.get(), ""
+	}
+	return ft.cwd.get(), p
+}
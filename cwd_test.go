@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestCwdRelative(t *testing.T) {
+	cases := []struct {
+		cwd  string
+		path string
+		want string
+	}{
+		{cwd: "", path: "src/main.go", want: ""},
+		{cwd: ".", path: "src/main.go", want: ""},
+		{cwd: "src", path: "src/main.go", want: "main.go"},
+		{cwd: "src", path: "src", want: "."},
+		{cwd: "src/pkg", path: "src/main.go", want: "../main.go"},
+		{cwd: "src/pkg", path: ".", want: "../.."},
+		{cwd: "src", path: "docs/readme.md", want: "../docs/readme.md"},
+	}
+
+	for _, c := range cases {
+		ft := fileTools{cwd: &workingDir{dir: c.cwd}}
+		if got := ft.cwdRelative(c.path); got != c.want {
+			t.Errorf("cwdRelative(%s) in %s got %s, want %s", c.path, c.cwd, got, c.want)
+		}
+	}
+}
+
+func TestWorkingDir(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "src", "main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "src", "pkg", "pkg.go"), []byte("package pkg"))
+	mustWriteFile(t, filepath.Join(tempDir, "docs", "readme.md"), []byte("readme"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+	}
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+	cases := []struct {
+		tool string
+		args map[string]any
+		want map[string]any
+		code string
+	}{
+		{tool: "pwd", args: map[string]any{}, want: map[string]any{"cwd": "."}},
+		{
+			tool: "read_file",
+			args: map[string]any{"path": "src/main.go"},
+			want: map[string]any{"path": "src/main.go", "relPath": nil},
+		},
+		{tool: "cd", args: map[string]any{"path": "src/"}, want: map[string]any{"cwd": "src"}},
+		{tool: "pwd", args: map[string]any{}, want: map[string]any{"cwd": "src"}},
+		{
+			tool: "read_file",
+			args: map[string]any{"path": "main.go"},
+			want: map[string]any{"path": "src/main.go", "relPath": "main.go"},
+		},
+		{
+			tool: "get_file_info",
+			args: map[string]any{"path": "../docs/readme.md"},
+			want: map[string]any{"path": "docs/readme.md", "relPath": "../docs/readme.md"},
+		},
+		{
+			tool: "list_directory",
+			args: map[string]any{},
+			want: map[string]any{"path": "src", "relPath": ".", "count": 2.0},
+		},
+		{
+			tool: "search_files",
+			args: map[string]any{"pattern": "*.go"},
+			want: map[string]any{
+				"matches":    []any{"src/main.go", "src/pkg/pkg.go"},
+				"relMatches": []any{"main.go", "pkg/pkg.go"},
+			},
+		},
+		{tool: "cd", args: map[string]any{"path": "pkg"}, want: map[string]any{"cwd": "src/pkg"}},
+		{
+			tool: "read_file",
+			args: map[string]any{"path": "../main.go"},
+			want: map[string]any{"path": "src/main.go", "relPath": "../main.go"},
+		},
+		{
+			tool: "read_file",
+			args: map[string]any{"path": filepath.Join(tempDir, "docs", "readme.md")},
+			want: map[string]any{"path": "docs/readme.md", "relPath": "../../docs/readme.md"},
+		},
+		{tool: "cd", args: map[string]any{"path": "../../.."}, code: "path_escape"},
+		{tool: "cd", args: map[string]any{"path": "pkg.go"}, code: "not_a_directory"},
+		{tool: "cd", args: map[string]any{"path": "missing"}, code: "not_found"},
+		{tool: "pwd", args: map[string]any{}, want: map[string]any{"cwd": "src/pkg"}},
+		{tool: "cd", args: map[string]any{"path": ""}, want: map[string]any{"cwd": "."}},
+		{
+			tool: "cd",
+			args: map[string]any{"path": filepath.Join(tempDir, "docs")},
+			want: map[string]any{"cwd": "docs"},
+		},
+		{tool: "cd", args: map[string]any{"path": ".."}, want: map[string]any{"cwd": "."}},
+	}
+
+	for _, c := range cases {
+		res := callTool(t, cs, c.tool, c.args)
+		out, _ := res.StructuredContent.(map[string]any)
+		if c.code != "" {
+			if !res.IsError || out["code"] != c.code {
+				t.Errorf("%s(%v) got %v, want %s", c.tool, c.args, res.StructuredContent, c.code)
+			}
+			continue
+		} else if res.IsError {
+			t.Errorf("%s(%v) failed with %v", c.tool, c.args, res.Content)
+			continue
+		}
+
+		for key, val := range c.want {
+			if !reflect.DeepEqual(out[key], val) {
+				t.Errorf("%s(%v) got %s %v, want %v", c.tool, c.args, key, out[key], val)
+			}
+		}
+	}
+}
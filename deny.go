@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// defaultDeny is the sensitive paths which are denied unless the default deny list is
+// disabled: credentials, keys, browser profiles, and environment files.
+var defaultDeny = []string{
+	".ssh",
+	".aws",
+	".gnupg",
+	".netrc",
+	".env",
+	".env.*",
+	".kube",
+	".docker/config.json",
+	".git-credentials",
+	".npmrc",
+	".pypirc",
+	".azure",
+	".config/gcloud",
+	".mozilla",
+	".config/google-chrome",
+	".config/chromium",
+	".config/BraveSoftware",
+	".config/microsoft-edge",
+	"snap/firefox",
+	"Library/Application Support/Google/Chrome",
+	"Library/Application Support/Firefox",
+	"Library/Application Support/BraveSoftware",
+	"Library/Application Support/Microsoft Edge",
+	"Library/Safari",
+	"AppData/Local/Google/Chrome",
+	"AppData/Local/Microsoft/Edge",
+	"AppData/Roaming/Mozilla",
+}
+
+// foldCase is true where file systems are case-insensitive by default, so that paths are
+// denied whatever their case.
+var foldCase = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// denyList is a list of patterns of paths which are hidden from and blocked for every
+// session. Each pattern is one or more slash separated path.Match patterns, which match
+// consecutive elements anywhere in a path. A path is denied if it or any of its parents
+// matches one of the patterns.
+type denyList []string
+
+// parseDenyList returns a deny list of patterns, and of defaultDeny, if useDefault.
+func parseDenyList(patterns []string, useDefault bool) (denyList, error) {
+	var dl denyList
+	if useDefault {
+		dl = append(dl, defaultDeny...)
+	}
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("deny: %s: %s", pattern, err)
+		}
+		dl = append(dl, pattern)
+	}
+
+	if foldCase {
+		for i, pattern := range dl {
+			dl[i] = strings.ToLower(pattern)
+		}
+	}
+	return dl, nil
+}
+
+func (dl denyList) denied(p string) bool {
+	if len(dl) == 0 {
+		return false
+	}
+	p = path.Clean(p)
+	if p == "." {
+		return false
+	}
+	if foldCase {
+		p = strings.ToLower(p)
+	}
+
+	elems := strings.Split(p, "/")
+	for _, pattern := range dl {
+		pelems := strings.Split(pattern, "/")
+		for i := 0; i+len(pelems) <= len(elems); i++ {
+			if matchElems(pelems, elems[i:i+len(pelems)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchElems(patterns, elems []string) bool {
+	for i, pattern := range patterns {
+		if matched, _ := path.Match(pattern, elems[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+var errPolicyDenied = errors.New("access denied by policy")
+
+func errDeniedByPolicy(path string) error {
+	return fmt.Errorf("%s: %w", path, errPolicyDenied)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestDenyList(t *testing.T) {
+	dl, err := parseDenyList([]string{"secrets/*.key", "/private/"}, true)
+	if err != nil {
+		t.Fatalf("parseDenyList() failed with %s", err)
+	}
+
+	cases := []struct {
+		path   string
+		denied bool
+	}{
+		{path: "."},
+		{path: "src/main.go"},
+		{path: ".ssh", denied: true},
+		{path: ".ssh/id_ed25519", denied: true},
+		{path: "home/.ssh/config", denied: true},
+		{path: "./.aws/credentials", denied: true},
+		{path: ".gnupg/pubring.kbx", denied: true},
+		{path: ".env", denied: true},
+		{path: "project/.env", denied: true},
+		{path: "project/.env.local", denied: true},
+		{path: "project/env"},
+		{path: "project/.envrc"},
+		{path: ".config/google-chrome/Default/Cookies", denied: true},
+		{path: ".config/git/config"},
+		{path: "Library/Application Support/Firefox/Profiles", denied: true},
+		{path: "Library/Application Support/Code"},
+		{path: "secrets/server.key", denied: true},
+		{path: "secrets/server.crt"},
+		{path: "project/private/notes.txt", denied: true},
+		{path: ".kube/config", denied: true},
+		{path: ".docker/config.json", denied: true},
+		{path: ".docker/daemon.json"},
+		{path: ".git-credentials", denied: true},
+		{path: "project/.npmrc", denied: true},
+		{path: ".pypirc", denied: true},
+		{path: ".azure/msal_token_cache.json", denied: true},
+		{path: ".config/gcloud/credentials.db", denied: true},
+	}
+
+	for _, c := range cases {
+		if denied := dl.denied(c.path); denied != c.denied {
+			t.Errorf("denied(%s) got %v, want %v", c.path, denied, c.denied)
+		}
+	}
+
+	dl, err = parseDenyList(nil, false)
+	if err != nil {
+		t.Fatalf("parseDenyList() failed with %s", err)
+	} else if dl.denied(".ssh/id_ed25519") {
+		t.Errorf("denied(.ssh/id_ed25519) got true without the default deny list")
+	}
+
+	defer func(fold bool) {
+		foldCase = fold
+	}(foldCase)
+	for _, fold := range []bool{false, true} {
+		foldCase = fold
+		dl, err = parseDenyList([]string{"Secrets"}, true)
+		if err != nil {
+			t.Fatalf("parseDenyList() failed with %s", err)
+		}
+		for _, p := range []string{".SSH/id_rsa", ".Env", "secrets/key", "Library/safari"} {
+			if denied := dl.denied(p); denied != fold {
+				t.Errorf("denied(%s) with foldCase %v got %v", p, fold, denied)
+			}
+		}
+	}
+
+	_, err = parseDenyList([]string{"[bad"}, true)
+	if err == nil {
+		t.Errorf("parseDenyList([bad) did not fail")
+	}
+}
+
+func TestDenyTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, ".ssh", "id_ed25519"), []byte("private key"))
+	mustWriteFile(t, filepath.Join(tempDir, ".env"), []byte("SECRET=1"))
+	mustWriteFile(t, filepath.Join(tempDir, "project", ".env.local"), []byte("SECRET=2"))
+	mustWriteFile(t, filepath.Join(tempDir, "project", "main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "private", "keys", "key.pem"), []byte("private key"))
+	mustSymlink(t, ".ssh", filepath.Join(tempDir, "keys"))
+	mustSymlink(t, "../.ssh/id_ed25519", filepath.Join(tempDir, "project", "key"))
+	mustSymlink(t, "../private", filepath.Join(tempDir, "project", "priv"))
+
+	deny, err := parseDenyList([]string{"private/keys"}, true)
+	if err != nil {
+		t.Fatalf("parseDenyList() failed with %s", err)
+	}
+	sf := &serverFactory{
+		fs:          openRootFS(t, tempDir, followInRoot, nil),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		deny:        deny,
+	}
+
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+	for _, c := range []struct {
+		tool string
+		args map[string]any
+	}{
+		{tool: "read_file", args: map[string]any{"path": ".ssh/id_ed25519"}},
+		{tool: "read_file", args: map[string]any{"path": "project/.env.local"}},
+		{tool: "get_file_info", args: map[string]any{"path": ".env"}},
+		{tool: "list_directory", args: map[string]any{"path": ".ssh"}},
+		{tool: "read_file", args: map[string]any{"path": "keys/id_ed25519"}},
+		{tool: "read_file", args: map[string]any{"path": "project/key"}},
+		{tool: "read_file", args: map[string]any{"path": "project/priv/keys/key.pem"}},
+		{tool: "list_directory", args: map[string]any{"path": "keys"}},
+		{tool: "list_directory", args: map[string]any{"path": "project/priv/keys"}},
+		{tool: "get_file_info", args: map[string]any{"path": "keys/id_ed25519"}},
+		{tool: "cd", args: map[string]any{"path": "keys"}},
+	} {
+		res := callTool(t, cs, c.tool, c.args)
+		if !res.IsError {
+			t.Errorf("%s(%v) did not fail", c.tool, c.args)
+		} else if txt := res.Content[0].(*mcp.TextContent).Text; !strings.Contains(txt,
+			"access denied by policy") {
+
+			t.Errorf("%s(%v) failed with %s, want access denied by policy", c.tool, c.args, txt)
+		}
+	}
+
+	res := callTool(t, cs, "list_directory", map[string]any{"path": ""})
+	if res.IsError {
+		t.Errorf("list_directory() failed with %v", res.Content)
+	} else if out := res.StructuredContent.(map[string]any); out["count"] != 3.0 {
+		t.Errorf("list_directory() got %v, want keys, private, and project", out["entries"])
+	} else {
+		for _, e := range out["entries"].([]any) {
+			entry := e.(map[string]any)
+			if entry["name"] == "keys" && entry["blocked"] != true {
+				t.Errorf("list_directory() got %v, want keys blocked", entry)
+			}
+		}
+	}
+
+	res = callTool(t, cs, "list_directory", map[string]any{"path": "project/priv"})
+	if res.IsError {
+		t.Errorf("list_directory(project/priv) failed with %v", res.Content)
+	} else if out := res.StructuredContent.(map[string]any); out["count"] != 0.0 {
+		t.Errorf("list_directory(project/priv) got %v, want none", out["entries"])
+	}
+
+	res = callTool(t, cs, "search_files", map[string]any{"pattern": "*"})
+	if res.IsError {
+		t.Errorf("search_files(*) failed with %v", res.Content)
+	} else if out := res.StructuredContent.(map[string]any); out["count"] != 2.0 {
+		t.Errorf("search_files(*) got %v, want project/main.go and project/priv",
+			out["matches"])
+	}
+
+	if res := callTool(t, cs, "cd", map[string]any{"path": "project/priv"}); res.IsError {
+		t.Errorf("cd(project/priv) failed with %v", res.Content)
+	}
+	res = callTool(t, cs, "search_files", map[string]any{"pattern": "*"})
+	if res.IsError {
+		t.Errorf("search_files(*) failed with %v", res.Content)
+	} else if out := res.StructuredContent.(map[string]any); out["count"] != 0.0 {
+		t.Errorf("search_files(*) in project/priv got %v, want none", out["matches"])
+	}
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "keys/key.pem"}); !res.IsError {
+		t.Errorf("read_file(keys/key.pem) in project/priv did not fail")
+	}
+	callTool(t, cs, "cd", map[string]any{"path": ""})
+
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "project/main.go"}); res.IsError {
+		t.Errorf("read_file(project/main.go) failed with %v", res.Content)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+var errTooLarge = errors.New("file too large")
+
+// toolError is the structured content of the result of a tool call which failed: a code,
+// which is stable, a message, and a hint at what to do about it.
+type toolError struct {
+	Code        string   `json:"code"`
+	Message     string   `json:"message"`
+	Hint        string   `json:"hint,omitempty"`
+	RetryAfter  float64  `json:"retryAfter,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+func (te toolError) result() *mcp.CallToolResult {
+	text := fmt.Sprintf("%s: %s", te.Code, te.Message)
+	if len(te.Suggestions) > 0 {
+		text += "\ndid you mean: " + strings.Join(te.Suggestions, ", ")
+	}
+	if te.Hint != "" {
+		text += "\nhint: " + te.Hint
+	}
+	return &mcp.CallToolResult{
+		Content:           []mcp.Content{&mcp.TextContent{Text: text}},
+		StructuredContent: te,
+		IsError:           true,
+	}
+}
+
+// errorDescriptions and errorHints are the message, for errors about a path, and the hint
+// of each error code.
+var (
+	errorDescriptions = map[string]string{
+		"not_found":         "no such file or directory",
+		"not_a_directory":   "not a directory",
+		"is_a_directory":    "is a directory",
+		"permission_denied": "permission denied",
+		"path_escape":       "outside of the roots",
+		"too_large":         "file too large",
+		"timeout":           "timed out",
+	}
+	errorHints = map[string]string{
+		"not_found":         "check the path with list_directory or search_files",
+		"not_a_directory":   "use read_file or get_file_info for files",
+		"is_a_directory":    "use list_directory for directories",
+		"permission_denied": "the server may not read this path; don't retry it",
+		"path_escape":       "use a path relative to a root; list_roots returns the roots",
+		"outside_roots":     "the client didn't share this path; ask the user to add it to the roots",
+		"policy_denied":     "this path or tool is not available to this session; don't retry it",
+		"too_large": fmt.Sprintf("files larger than %d MB can't be summarized; read them in "+
+			"parts with read_file instead", summaryMaxChunks*summaryChunkSize>>20),
+		"invalid_pattern":  "use a glob pattern, such as *.go or test_*",
+		"invalid_argument": "offsets start at 0",
+		"timeout":          "retry the call",
+		"canceled":         "the call was canceled by the client",
+		"rate_limited":     "retry the call after retryAfter seconds",
+		"not_confirmed":    "the user didn't allow this call; don't retry it unless the user asks",
+		"shutting_down":    "retry the call once the server has restarted",
+	}
+)
+
+// errorCode returns the code of err.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, syscall.ENOTDIR):
+		return "not_a_directory"
+	case errors.Is(err, syscall.EISDIR):
+		return "is_a_directory"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission_denied"
+	case errors.Is(err, errOutsideOfRoots):
+		return "outside_roots"
+	case errors.Is(err, errPathEscape), errors.Is(err, fs.ErrInvalid),
+		pathEscapes(err):
+		return "path_escape"
+	case errors.Is(err, errUnauthorized), errors.Is(err, errPolicyDenied),
+		errors.Is(err, errSymlink):
+		return "policy_denied"
+	case errors.Is(err, errTooLarge):
+		return "too_large"
+	case errors.Is(err, filepath.ErrBadPattern):
+		return "invalid_pattern"
+	case errors.Is(err, errNegativeOffset):
+		return "invalid_argument"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, errRateLimit):
+		return "rate_limited"
+	case errors.Is(err, errNotConfirmed):
+		return "not_confirmed"
+	case errors.Is(err, errShuttingDown):
+		return "shutting_down"
+	}
+	return "internal"
+}
+
+// newToolError returns the tool error of err, from a call with args. Errors about a path are
+// reported with the path the client used, and not the path on the server.
+func newToolError(err error, args json.RawMessage) toolError {
+	code := errorCode(err)
+
+	var params struct {
+		Path    string `json:"path"`
+		Pattern string `json:"pattern"`
+	}
+	json.Unmarshal(args, &params)
+
+	msg := err.Error()
+	var pe *fs.PathError
+	if desc, ok := errorDescriptions[code]; ok && errors.As(err, &pe) {
+		p := params.Path
+		if p == "" {
+			p = pe.Path
+		}
+		msg = fmt.Sprintf("%s: %s", p, desc)
+	} else if code == "invalid_pattern" {
+		msg = fmt.Sprintf("%s: %s", params.Pattern, err)
+	}
+
+	te := toolError{
+		Code:    code,
+		Message: msg,
+		Hint:    errorHints[code],
+	}
+	var se *suggestError
+	if errors.As(err, &se) {
+		te.Suggestions = se.suggestions
+	}
+	return te
+}
+
+// callError holds the error which a tool handler failed with.
+type callError struct {
+	err error
+}
+
+type callErrorKey struct{}
+
+// setCallError records that the tool call of ctx failed with err.
+func setCallError(ctx context.Context, err error) {
+	if ce, ok := ctx.Value(callErrorKey{}).(*callError); ok {
+		ce.err = err
+	}
+}
+
+// toolErrors is middleware which replaces the result of a tool call which failed with a
+// tool error.
+func toolErrors(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		ctr, ok := req.(*mcp.CallToolRequest)
+		if !ok || method != "tools/call" {
+			return next(ctx, method, req)
+		}
+
+		ce := &callError{}
+		res, err := next(context.WithValue(ctx, callErrorKey{}, ce), method, req)
+		if err != nil || ce.err == nil {
+			return res, err
+		}
+
+		auditError(ctx, ce.err)
+		te := newToolError(ce.err, ctr.Params.Arguments)
+		slog.Info("tool failed", "tool", ctr.Params.Name, "code", te.Code, "error", ce.err)
+		return te.result(), nil
+	}
+}
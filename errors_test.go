@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestErrorCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code string
+	}{
+		{err: &fs.PathError{Op: "open", Path: "a", Err: fs.ErrNotExist}, code: "not_found"},
+		{err: &fs.PathError{Op: "open", Path: "a", Err: syscall.ENOENT}, code: "not_found"},
+		{err: &fs.PathError{Op: "readdirent", Path: "a", Err: syscall.ENOTDIR},
+			code: "not_a_directory"},
+		{err: &fs.PathError{Op: "read", Path: "a", Err: syscall.EISDIR},
+			code: "is_a_directory"},
+		{err: &fs.PathError{Op: "open", Path: "a", Err: fs.ErrPermission},
+			code: "permission_denied"},
+		{err: &fs.PathError{Op: "open", Path: "../a", Err: fs.ErrInvalid},
+			code: "path_escape"},
+		{err: fmt.Errorf("openat link: path escapes from parent"), code: "path_escape"},
+		{err: errOutsideRoots("a"), code: "outside_roots"},
+		{err: errNotAuthorized("a"), code: "policy_denied"},
+		{err: errDeniedByPolicy("a"), code: "policy_denied"},
+		{err: &fs.PathError{Op: "read", Path: "a", Err: errTooLarge}, code: "too_large"},
+		{err: filepath.ErrBadPattern, code: "invalid_pattern"},
+		{err: fmt.Errorf("read_file: %w", errNegativeOffset), code: "invalid_argument"},
+		{err: fmt.Errorf("wait: %w", context.Canceled), code: "canceled"},
+		{err: context.DeadlineExceeded, code: "timeout"},
+		{err: &rateLimitError{tool: "read_file"}, code: "rate_limited"},
+		{err: fmt.Errorf("read_file: %w", errNotConfirmed), code: "not_confirmed"},
+		{err: errShuttingDown, code: "shutting_down"},
+		{err: fmt.Errorf("something else"), code: "internal"},
+	}
+
+	for _, c := range cases {
+		if code := errorCode(c.err); code != c.code {
+			t.Errorf("errorCode(%s) got %s, want %s", c.err, code, c.code)
+		}
+	}
+}
+
+func TestNewToolError(t *testing.T) {
+	cases := []struct {
+		err  error
+		args string
+		want toolError
+	}{
+		{
+			err:  &fs.PathError{Op: "openat", Path: "/srv/root/missing.txt", Err: syscall.ENOENT},
+			args: `{"path": "missing.txt"}`,
+			want: toolError{
+				Code:    "not_found",
+				Message: "missing.txt: no such file or directory",
+				Hint:    errorHints["not_found"],
+			},
+		},
+		{
+			err: &fs.PathError{Op: "open", Path: "../x", Err: fs.ErrInvalid},
+			want: toolError{
+				Code:    "path_escape",
+				Message: "../x: outside of the roots",
+				Hint:    errorHints["path_escape"],
+			},
+		},
+		{
+			err:  errDeniedByPolicy(".ssh"),
+			args: `{"path": ".ssh"}`,
+			want: toolError{
+				Code:    "policy_denied",
+				Message: ".ssh: access denied by policy",
+				Hint:    errorHints["policy_denied"],
+			},
+		},
+		{
+			err:  filepath.ErrBadPattern,
+			args: `{"pattern": "[a"}`,
+			want: toolError{
+				Code:    "invalid_pattern",
+				Message: "[a: syntax error in pattern",
+				Hint:    errorHints["invalid_pattern"],
+			},
+		},
+		{
+			err:  fmt.Errorf("sampling failed"),
+			args: `{"path": "file.txt"}`,
+			want: toolError{
+				Code:    "internal",
+				Message: "sampling failed",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		if te := newToolError(c.err, json.RawMessage(c.args)); !reflect.DeepEqual(te, c.want) {
+			t.Errorf("newToolError(%s, %s) got %+v, want %+v", c.err, c.args, te, c.want)
+		}
+	}
+}
+
+func TestToolErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "dir", "file.txt"), []byte("content"))
+	err := os.Symlink("/etc/passwd", filepath.Join(tempDir, "passwd"))
+	if err != nil {
+		t.Fatalf("Symlink() failed with %s", err)
+	}
+
+	root, err := os.OpenRoot(tempDir)
+	if err != nil {
+		t.Fatalf("OpenRoot(%s) failed with %s", tempDir, err)
+	}
+	defer root.Close()
+
+	sf := &serverFactory{
+		fs:          root.FS(),
+		serverRoots: []serverRoot{{dir: tempDir}},
+	}
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+	cases := []struct {
+		tool string
+		args map[string]any
+		code string
+	}{
+		{tool: "read_file", args: map[string]any{"path": "missing.txt"}, code: "not_found"},
+		{tool: "read_file", args: map[string]any{"path": "dir"}, code: "is_a_directory"},
+		{tool: "read_file", args: map[string]any{"path": "../x"}, code: "path_escape"},
+		{tool: "read_file", args: map[string]any{"path": "passwd"}, code: "path_escape"},
+		{tool: "list_directory", args: map[string]any{"path": "dir/file.txt"},
+			code: "not_a_directory"},
+		{tool: "search_files", args: map[string]any{"pattern": "[a"}, code: "invalid_pattern"},
+	}
+
+	for _, c := range cases {
+		res := callTool(t, cs, c.tool, c.args)
+		if !res.IsError {
+			t.Errorf("%s(%v) did not fail", c.tool, c.args)
+			continue
+		}
+
+		out, ok := res.StructuredContent.(map[string]any)
+		if !ok || out["code"] != c.code {
+			t.Errorf("%s(%v) got %v, want %s", c.tool, c.args, res.StructuredContent, c.code)
+		} else if out["message"] == nil || out["hint"] == nil {
+			t.Errorf("%s(%v) got %v, want message and hint", c.tool, c.args, out)
+		} else if msg := out["message"].(string); strings.Contains(msg, tempDir) {
+			t.Errorf("%s(%v) got message %s, which has the server path", c.tool, c.args, msg)
+		}
+		if txt := res.Content[0].(*mcp.TextContent).Text; !strings.HasPrefix(txt, c.code+": ") {
+			t.Errorf("%s(%v) got %s", c.tool, c.args, txt)
+		}
+	}
+}
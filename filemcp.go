@@ -1,39 +1,38 @@
-/*
-To Do:
-- Authenticate with a shared secret
-- Add protocol logging for sse and streaming transports
-
-- https://github.com/FiloSottile/mkcert
-*/
-
 package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
-func rootDirectory(args []string) (string, error) {
-	var rootDir string
-	switch len(args) {
-	case 0:
-		var err error
-		rootDir, err = os.UserHomeDir()
+// rootDirectory returns the absolute path of the directory named by arg, which may start
+// with ~ for the home directory. An empty arg is the home directory.
+func rootDirectory(arg string) (string, error) {
+	rootDir := arg
+	if arg == "" || arg == "~" || strings.HasPrefix(arg, "~/") {
+		homeDir, err := os.UserHomeDir()
 		if err != nil {
 			return "", err
 		}
-	case 1:
-		rootDir = args[0]
-	default:
-		return "", fmt.Errorf("too many arguments: %s", strings.Join(os.Args, " "))
+		rootDir = filepath.Join(homeDir, strings.TrimPrefix(strings.TrimPrefix(arg, "~"), "/"))
 	}
 
 	rootDir, err := filepath.Abs(rootDir)
@@ -51,6 +50,103 @@ func rootDirectory(args []string) (string, error) {
 	return rootDir, nil
 }
 
+// parseRoots returns the roots named by args. With no arguments, the home directory is the
+// root. A single directory may be given as the root; otherwise, each root must be given as
+// name[:ro|:rw]=directory. Roots are read-only unless otherwise specified.
+func parseRoots(args []string) ([]serverRoot, error) {
+	if len(args) == 0 || (len(args) == 1 && !strings.Contains(args[0], "=")) {
+		var arg string
+		if len(args) == 1 {
+			arg = args[0]
+		}
+		rootDir, err := rootDirectory(arg)
+		if err != nil {
+			return nil, err
+		}
+		return []serverRoot{{dir: rootDir, perm: readOnly}}, nil
+	}
+
+	var roots []serverRoot
+	for _, arg := range args {
+		name, dir, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("multiple roots must be named: %s", arg)
+		}
+
+		perm := readOnly
+		if n, p, ok := strings.Cut(name, ":"); ok {
+			var err error
+			perm, err = parsePermission(p)
+			if err != nil {
+				return nil, err
+			}
+			name = n
+		}
+
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\:`) {
+			return nil, fmt.Errorf("bad root name: %s", arg)
+		} else if slices.ContainsFunc(roots, func(sr serverRoot) bool {
+			return sr.name == name
+		}) {
+			return nil, fmt.Errorf("duplicate root name: %s", name)
+		} else if dir == "" {
+			return nil, fmt.Errorf("missing root directory: %s", arg)
+		}
+
+		rootDir, err := rootDirectory(dir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, serverRoot{name: name, dir: rootDir, perm: perm})
+	}
+
+	return roots, nil
+}
+
+// openRoots opens each of the roots and returns a file system containing all of them.
+// Symbolic links are followed according to policy; allowed is the directories which
+// symbolic links may lead to, besides the roots, if policy is followAllowed, unless the path
+// which they lead to is denied.
+func openRoots(roots []serverRoot, policy symlinkPolicy, allowed []*rootFS,
+	deny denyList) (fs.FS, func(), error) {
+
+	var osRoots []*os.Root
+	closeRoots := func() {
+		for _, root := range osRoots {
+			root.Close()
+		}
+	}
+
+	for i := range roots {
+		root, err := os.OpenRoot(roots[i].dir)
+		if err != nil {
+			closeRoots()
+			return nil, nil, err
+		}
+		osRoots = append(osRoots, root)
+		roots[i].fs = newRootFS(root, roots[i].dir, policy, allowed, deny)
+	}
+
+	if len(roots) == 1 && roots[0].name == "" {
+		return roots[0].fs, closeRoots, nil
+	}
+	return rootsFS(roots), closeRoots, nil
+}
+
+// stateDirectory returns the directory where filemcp keeps state between runs. If dir is
+// empty, it is filemcp in the user's configuration directory. The directory is created when
+// state is first written to it.
+func stateDirectory(dir string) (string, error) {
+	if dir == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(configDir, "filemcp")
+	}
+	return dir, nil
+}
+
 func setupLogging(log bool, logfile string) {
 	if log {
 		if logfile != "" {
@@ -69,23 +165,6 @@ func setupLogging(log bool, logfile string) {
 	}
 }
 
-func setupTransport(logProto string, t mcp.Transport) mcp.Transport {
-	if logProto != "" {
-		file, err := os.OpenFile(logProto, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			slog.Error("open file", "logproto", logProto, "error", err)
-			return t
-		}
-
-		return &mcp.LoggingTransport{
-			Transport: t,
-			Writer:    file,
-		}
-	}
-
-	return t
-}
-
 func fatal(err error) {
 	slog.Error(err.Error())
 	fmt.Fprintf(os.Stderr, "%s: %s", os.Args[0], err)
@@ -93,109 +172,337 @@ func fatal(err error) {
 }
 
 func main() {
-	var log bool
-	var logfile string
-	var logProto string
-	var useStdio bool
-	var useSSE bool
-	var useHTTP bool
-	var httpsAddr string
-	var tlsCert string
-	var tlsKey string
-
-	flag.BoolVar(&log, "log", false, "enable logging")
-	flag.StringVar(&logfile, "logfile", "", "log file path")
-	flag.StringVar(&logProto, "logproto", "", "protocol log file path")
-	flag.BoolVar(&useStdio, "stdio", false, "use stdio transport")
-	flag.BoolVar(&useSSE, "sse", false, "use SSE transport at /sse (requires -cert and -key)")
-	flag.BoolVar(&useHTTP, "http", false, "use streaming HTTP transport at /mcp (requires -cert and -key)")
-	flag.StringVar(&httpsAddr, "addr", ":8443", "HTTPS server address")
-	flag.StringVar(&tlsCert, "cert", "", "TLS certificate file (required for -sse or -http)")
-	flag.StringVar(&tlsKey, "key", "", "TLS key file (required for -sse or -http)")
-	flag.Parse()
-
-	if !useStdio && !useSSE && !useHTTP {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(auditCommand(os.Args[2:]))
+	}
+
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], err)
+		os.Exit(1)
+	}
+
+	if !cfg.Stdio && !cfg.SSE && !cfg.HTTP {
 		fmt.Fprintf(os.Stderr, "at least one of -stdio, -sse, or -http must be specified\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if (useSSE || useHTTP) && (tlsCert == "" || tlsKey == "") {
-		fmt.Fprintf(os.Stderr, "-cert and -key are required for -sse or -http transport\n")
+	if cfg.SelfSigned && (cfg.Cert != "" || cfg.Key != "") {
+		fmt.Fprintf(os.Stderr, "-selfsigned may not be used with -cert or -key\n")
+		flag.Usage()
+		os.Exit(1)
+	} else if (cfg.Cert == "") != (cfg.Key == "") {
+		fmt.Fprintf(os.Stderr, "-cert and -key must be used together\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	setupLogging(log, logfile)
+	useHTTP := cfg.SSE || cfg.HTTP
+	useTLS := cfg.Cert != "" || cfg.SelfSigned
+	if useHTTP && cfg.Addr == "" && cfg.Unix == "" {
+		fmt.Fprintf(os.Stderr, "-addr or -unix is required for -sse or -http transport\n")
+		flag.Usage()
+		os.Exit(1)
+	} else if useHTTP && cfg.Addr != "" && !useTLS && !loopbackAddr(cfg.Addr) {
+		fmt.Fprintf(os.Stderr, "-cert and -key, or -selfsigned, are required for -sse or -http "+
+			"transport on a non-loopback -addr\n")
+		flag.Usage()
+		os.Exit(1)
+	} else if cfg.ClientCA != "" && !useTLS {
+		fmt.Fprintf(os.Stderr, "-client-ca requires -cert and -key, or -selfsigned\n")
+		flag.Usage()
+		os.Exit(1)
+	} else if cfg.ClientCA != "" && cfg.Unix != "" {
+		// The socket is served over plain HTTP, so clients can't present certificates.
+		fmt.Fprintf(os.Stderr, "-client-ca can't be used with -unix\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	socketMode, err := strconv.ParseUint(cfg.UnixMode, 8, 32)
+	if err != nil || socketMode&^0777 != 0 {
+		fmt.Fprintf(os.Stderr, "-unixmode must be an octal file mode\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	setupLogging(cfg.Log, cfg.LogFile)
 	slog.Info("starting", "cmd", os.Args[0], "args", strings.Join(os.Args[1:], " "),
 		"pid", os.Getpid())
+	if cfg.Config != "" {
+		slog.Info("loaded configuration", "config", cfg.Config)
+	}
 
-	rootDir, err := rootDirectory(flag.Args())
+	stateDir, err := stateDirectory(cfg.State)
 	if err != nil {
 		fatal(err)
 	}
 
-	root, err := os.OpenRoot(rootDir)
+	tlsCert, tlsKey := cfg.Cert, cfg.Key
+	if cfg.SelfSigned && useHTTP {
+		var caCert *x509.Certificate
+		tlsCert, tlsKey, caCert, err = selfSignedCert(stateDir, cfg.Hostnames)
+		if err != nil {
+			fatal(err)
+		}
+
+		fingerprint := certFingerprint(caCert)
+		slog.Info("using self-signed certificate", "cert", tlsCert, "ca", caCert.Subject,
+			"fingerprint", fingerprint)
+		fmt.Fprintf(os.Stderr, "%s: local CA: %s\n", os.Args[0],
+			filepath.Join(filepath.Dir(tlsCert), "ca.pem"))
+		fmt.Fprintf(os.Stderr, "%s: local CA SHA-256 fingerprint: %s\n", os.Args[0],
+			fingerprint)
+	}
+
+	roots, err := parseRoots(cfg.Roots)
 	if err != nil {
 		fatal(err)
 	}
-	defer root.Close()
 
-	srvr := mcp.NewServer(&mcp.Implementation{
-		Name:    "filemcp",
-		Version: "0.1.0",
-	}, nil)
+	symlinks, err := parseSymlinkPolicy(cfg.Symlinks)
+	if err != nil {
+		fatal(err)
+	} else if symlinks == followAllowed && len(cfg.SymlinkDirs) == 0 {
+		fatal(fmt.Errorf("-symlinks allow requires -symlinkdirs"))
+	} else if symlinks != followAllowed && len(cfg.SymlinkDirs) > 0 {
+		fatal(fmt.Errorf("-symlinkdirs requires -symlinks allow"))
+	}
+	allowed, closeAllowed, err := openAllowedDirs(cfg.SymlinkDirs)
+	if err != nil {
+		fatal(err)
+	}
+
+	deny, err := parseDenyList(cfg.Deny, !cfg.NoDefaultDeny)
+	if err != nil {
+		fatal(err)
+	}
+	if cfg.NoDefaultDeny {
+		slog.Warn("default deny list disabled: sensitive paths may be read")
+	}
+
+	rootsFS, closeRoots, err := openRoots(roots, symlinks, allowed, deny)
+	if err != nil {
+		fatal(err)
+	}
 
-	ft := fileTools{
-		fs: root.FS(),
+	for _, sr := range roots {
+		slog.Info("serving root", "name", sr.name, "dir", sr.dir, "permission", sr.perm)
 	}
-	ft.registerTools(srvr)
 
-	ctx := context.Background()
+	policy, err := cfg.accessPolicy()
+	if err != nil {
+		fatal(err)
+	}
 
-	useHTTPS := useSSE || useHTTP
-	numTransports := 0
-	if useHTTPS {
-		numTransports++
+	redactor, err := newRedactor(cfg.Redact, !cfg.NoRedact, cfg.RedactEntropy)
+	if err != nil {
+		fatal(err)
 	}
-	if useStdio {
-		numTransports++
+	if cfg.NoRedact {
+		slog.Warn("built-in secret detectors disabled: secrets may be returned to clients")
 	}
 
-	errChan := make(chan error, numTransports)
+	tokens, err := loadTokens(cfg.Tokens)
+	if err != nil {
+		fatal(err)
+	}
 
-	if useHTTPS {
+	limits, err := parseLimitPolicy(cfg.Limits)
+	if err != nil {
+		fatal(err)
+	}
+	confirm, err := parseConfirm(cfg.Confirm)
+	if err != nil {
+		fatal(err)
+	}
+	ignore, err := parseIgnore(cfg.Ignore)
+	if err != nil {
+		fatal(err)
+	}
+
+	var protoFile io.Writer
+	if cfg.LogProto != "" {
+		file, err := os.OpenFile(cfg.LogProto, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			fatal(err)
+		}
+		defer file.Close()
+		protoFile = file
+	}
+
+	sf := &serverFactory{
+		fs:          rootsFS,
+		ignore:      ignore,
+		serverRoots: roots,
+		stateDir:    stateDir,
+		drainer:     &drainer{},
+		deny:        deny,
+		redactor:    redactor,
+		confirm:     confirm,
+		policy:      policy,
+		tokens:      tokens,
+		limiter:     newLimiter(limits),
+		maxBytes:    cfg.MaxBytes,
+		maxItems:    cfg.MaxItems,
+		index:       newNameIndex(rootsFS, ignore, deny),
+	}
+	if cfg.Audit != "" {
+		var key ed25519.PrivateKey
+		if cfg.AuditKey != "" {
+			key, err = loadAuditKey(cfg.AuditKey)
+			if err != nil {
+				fatal(err)
+			}
+		}
+		sf.audit, err = openAuditLog(cfg.Audit, cfg.AuditMaxSize*1024*1024, cfg.AuditKeep,
+			key, cfg.AuditCheckpoint)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if protoFile != nil || cfg.LogProtoDir != "" {
+		sf.protoLog = &protoLog{
+			w:   protoFile,
+			dir: cfg.LogProtoDir,
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	hupChan := make(chan os.Signal, 1)
+	if cfg.Config != "" {
+		signal.Notify(hupChan, syscall.SIGHUP)
+	}
+
+	// The stdio transport keeps running until the tool calls in flight have been drained.
+	ctx, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+
+	errChan := make(chan error, 3)
+	var httpServer *http.Server
+
+	if useHTTP {
 		mux := http.NewServeMux()
-		if useSSE {
+		if cfg.SSE {
 			slog.Info("adding SSE handler", "path", "/sse")
-			mux.Handle("/sse", mcp.NewSSEHandler(func(r *http.Request) *mcp.Server {
-				return srvr
-			}, nil))
+			mux.Handle("/sse", mcp.NewSSEHandler(sf.getServer("sse"), nil))
 		}
-		if useHTTP {
+		if cfg.HTTP {
 			slog.Info("adding streaming HTTP handler", "path", "/mcp")
-			mux.Handle("/mcp", mcp.NewStreamableHTTPHandler(func(r *http.Request) *mcp.Server {
-				return srvr
-			}, nil))
+			mux.Handle("/mcp", mcp.NewStreamableHTTPHandler(sf.getServer("http"), nil))
 		}
 
-		go func() {
-			slog.Info("starting HTTPS server", "addr", httpsAddr)
-			errChan <- http.ListenAndServeTLS(httpsAddr, tlsCert, tlsKey, mux)
-		}()
+		var handler http.Handler = mux
+		if cfg.JWKS != "" {
+			if len(tokens) > 0 {
+				fatal(fmt.Errorf("-jwks and bearer tokens may not both be used"))
+			}
+			jv, err := newJWTVerifier(cfg.JWKS, cfg.Issuer, cfg.Resource)
+			if err != nil {
+				fatal(err)
+			}
+
+			slog.Info("requiring JWT access tokens", "jwks", cfg.JWKS, "issuer", cfg.Issuer,
+				"resource", cfg.Resource)
+			authMux := http.NewServeMux()
+			authMux.Handle(protectedResourcePath, jv.metadataHandler())
+			authMux.Handle(protectedResourcePath+"/", jv.metadataHandler())
+			authMux.Handle("/", jv.requireJWT()(handler))
+			handler = authMux
+		} else if len(tokens) > 0 {
+			slog.Info("requiring bearer tokens", "count", len(tokens))
+			handler = requireTokens(sf.bearerTokens)(handler)
+		} else {
+			slog.Warn("no bearer tokens: HTTP server does not require authentication")
+		}
+
+		httpServer = &http.Server{
+			Addr:    cfg.Addr,
+			Handler: handler,
+		}
+		if cfg.ClientCA != "" {
+			httpServer.TLSConfig, err = clientCertConfig(cfg.ClientCA)
+			if err != nil {
+				fatal(err)
+			}
+		}
+
+		if cfg.Addr != "" {
+			go func() {
+				if useTLS {
+					slog.Info("starting HTTPS server", "addr", cfg.Addr)
+					errChan <- httpServer.ListenAndServeTLS(tlsCert, tlsKey)
+				} else {
+					slog.Warn("starting HTTP server without TLS", "addr", cfg.Addr)
+					errChan <- httpServer.ListenAndServe()
+				}
+			}()
+		}
+
+		if cfg.Unix != "" {
+			ln, err := listenUnix(cfg.Unix, os.FileMode(socketMode))
+			if err != nil {
+				fatal(err)
+			}
+			defer ln.Close()
+
+			go func() {
+				slog.Info("starting HTTP server", "unix", cfg.Unix, "mode",
+					os.FileMode(socketMode))
+				errChan <- httpServer.Serve(ln)
+			}()
+		}
 	}
 
-	if useStdio {
+	if cfg.Stdio {
 		go func() {
 			slog.Info("starting stdio transport")
-			errChan <- srvr.Run(ctx, setupTransport(logProto, &mcp.StdioTransport{}))
+			errChan <- sf.stdioServer().Run(ctx, &mcp.StdioTransport{})
 		}()
 	}
 
-	// Wait for any transport to fail
-	err = <-errChan
+	// Wait for a signal or for any transport to fail; reload the configuration on SIGHUP.
+	tokensRequired := useHTTP && cfg.JWKS == "" && len(tokens) > 0
+	for done := false; !done; {
+		select {
+		case err = <-errChan:
+			done = true
+		case sig := <-sigChan:
+			slog.Info("shutting down", "signal", sig)
+			done = true
+		case <-hupChan:
+			cfg = reloadConfig(sf, cfg, tokensRequired)
+		}
+	}
+	signal.Stop(sigChan)
+	signal.Stop(hupChan)
 
-	if err != nil {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(),
+		time.Duration(cfg.Shutdown))
+	defer cancel()
+
+	if derr := sf.drainer.drain(shutdownCtx); derr != nil {
+		slog.Warn("tool calls did not finish", "error", derr)
+	}
+	sf.drainer.closeSessions()
+	cancelRun()
+
+	if httpServer != nil {
+		if serr := httpServer.Shutdown(shutdownCtx); serr != nil {
+			slog.Warn("shutdown HTTP server", "error", serr)
+			httpServer.Close()
+		}
+	}
+	closeRoots()
+	closeAllowed()
+	if sf.audit != nil {
+		sf.audit.close()
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		fatal(err)
 	}
 
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
 )
@@ -38,28 +39,27 @@ func TestRootDirectory(t *testing.T) {
 	}
 
 	cases := []struct {
-		args []string
+		arg  string
 		r    string
 		fail bool
 		goos string
 	}{
-		{args: []string{}, r: homeDir},
-		{args: []string{"."}, r: cwd},
-		{args: []string{tempDir}, r: tempDir},
-		{args: []string{"arg1", "arg2"}, fail: true},
-		{args: []string{"arg1", "arg2", "arg3"}, fail: true},
-		{args: []string{"/bad/dog/food"}, fail: true},
-		{args: []string{tempFile}, fail: true},
-		{args: []string{"/"}, r: "/", goos: "linux"},
-		{args: []string{"/"}, r: "/", goos: "darwin"},
-		{args: []string{"/"}, r: `C:\`, goos: "windows"},
-		{args: []string{homeDir}, r: homeDir},
-		{args: []string{"/", homeDir}, fail: true},
-		{args: []string{homeDir, "/"}, fail: true},
-		{args: []string{tempSymlink}, r: tempSymlink, goos: "linux"},
-		{args: []string{tempSymlink}, r: tempSymlink, goos: "darwin"},
-		{args: []string{tempSubdir}, r: tempSubdir},
-		{args: []string{"./."}, r: cwd},
+		{arg: "", r: homeDir},
+		{arg: "~", r: homeDir},
+		{arg: "~/", r: homeDir},
+		{arg: ".", r: cwd},
+		{arg: tempDir, r: tempDir},
+		{arg: "/bad/dog/food", fail: true},
+		{arg: "~/bad/dog/food", fail: true},
+		{arg: tempFile, fail: true},
+		{arg: "/", r: "/", goos: "linux"},
+		{arg: "/", r: "/", goos: "darwin"},
+		{arg: "/", r: `C:\`, goos: "windows"},
+		{arg: homeDir, r: homeDir},
+		{arg: tempSymlink, r: tempSymlink, goos: "linux"},
+		{arg: tempSymlink, r: tempSymlink, goos: "darwin"},
+		{arg: tempSubdir, r: tempSubdir},
+		{arg: "./.", r: cwd},
 	}
 
 	for _, c := range cases {
@@ -67,15 +67,104 @@ func TestRootDirectory(t *testing.T) {
 			continue
 		}
 
-		r, err := rootDirectory(c.args)
+		r, err := rootDirectory(c.arg)
 		if err != nil {
 			if !c.fail {
-				t.Errorf("rootDirectory(%s) failed with %s", c.args, err)
+				t.Errorf("rootDirectory(%s) failed with %s", c.arg, err)
 			}
 		} else if c.fail {
-			t.Errorf("rootDirectory(%s) did not fail", c.args)
+			t.Errorf("rootDirectory(%s) did not fail", c.arg)
 		} else if r != c.r {
-			t.Errorf("rootDirectory(%s) got %s want %s", c.args, r, c.r)
+			t.Errorf("rootDirectory(%s) got %s want %s", c.arg, r, c.r)
 		}
 	}
 }
+
+func TestParseRoots(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd() failed with %s", err)
+	}
+
+	tempDir := t.TempDir()
+	tempSubdir := filepath.Join(tempDir, "subdir")
+	err = os.Mkdir(tempSubdir, 0755)
+	if err != nil {
+		t.Fatalf("Mkdir(%s) failed with %s", tempSubdir, err)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("UserHomeDir() failed with %s", err)
+	}
+
+	cases := []struct {
+		args  []string
+		roots []serverRoot
+		fail  bool
+	}{
+		{args: []string{}, roots: []serverRoot{{dir: homeDir, perm: readOnly}}},
+		{args: []string{"."}, roots: []serverRoot{{dir: cwd, perm: readOnly}}},
+		{args: []string{tempDir}, roots: []serverRoot{{dir: tempDir, perm: readOnly}}},
+		{
+			args:  []string{"temp=" + tempDir},
+			roots: []serverRoot{{name: "temp", dir: tempDir, perm: readOnly}},
+		},
+		{
+			args: []string{"temp:rw=" + tempDir, "sub:ro=" + tempSubdir, "home=~"},
+			roots: []serverRoot{
+				{name: "temp", dir: tempDir, perm: readWrite},
+				{name: "sub", dir: tempSubdir, perm: readOnly},
+				{name: "home", dir: homeDir, perm: readOnly},
+			},
+		},
+		{args: []string{"arg1", "arg2"}, fail: true},
+		{args: []string{"arg1", "arg2", "arg3"}, fail: true},
+		{args: []string{"/", homeDir}, fail: true},
+		{args: []string{homeDir, "/"}, fail: true},
+		{args: []string{"temp=" + tempDir, tempSubdir}, fail: true},
+		{args: []string{"temp=" + tempDir, "temp=" + tempSubdir}, fail: true},
+		{args: []string{"temp:rx=" + tempDir}, fail: true},
+		{args: []string{"=" + tempDir}, fail: true},
+		{args: []string{"..=" + tempDir}, fail: true},
+		{args: []string{"a/b=" + tempDir}, fail: true},
+		{args: []string{"temp="}, fail: true},
+		{args: []string{"bad=/bad/dog/food"}, fail: true},
+	}
+
+	for _, c := range cases {
+		roots, err := parseRoots(c.args)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("parseRoots(%s) failed with %s", c.args, err)
+			}
+		} else if c.fail {
+			t.Errorf("parseRoots(%s) did not fail", c.args)
+		} else if !reflect.DeepEqual(roots, c.roots) {
+			t.Errorf("parseRoots(%s) got %v want %v", c.args, roots, c.roots)
+		}
+	}
+}
+
+func TestStateDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	stateDir, err := stateDirectory(dir)
+	if err != nil {
+		t.Fatalf("stateDirectory(%s) failed with %s", dir, err)
+	} else if stateDir != dir {
+		t.Errorf("stateDirectory(%s) got %s", dir, stateDir)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("stateDirectory(%s) created the directory", dir)
+	}
+
+	_, _, _, err = selfSignedCert(stateDir, nil)
+	if err != nil {
+		t.Fatalf("selfSignedCert(%s) failed with %s", stateDir, err)
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		t.Errorf("Stat(%s) failed with %s", dir, err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0700 {
+		t.Errorf("Stat(%s) got mode %s, want 0700", dir, fi.Mode().Perm())
+	}
+}
@@ -2,26 +2,59 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"path"
 	"path/filepath"
+	"slices"
+	"time"
+	"unicode/utf8"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
 type fileTools struct {
-	fs fs.FS
+	fs          fs.FS
+	ignore      []string
+	roots       *clientRoots
+	serverRoots []serverRoot
+	session     sessionInfo
+	stateDir    string
+	access      *sessionAccess
+	deny        denyList
+	redactor    *redactor
+	maxBytes    int
+	maxItems    int
+	index       *nameIndex
+	cwd         *workingDir
 }
 
+// maxFileSize is the most of a file which is read at once.
+const maxFileSize = 64 * 1024 * 1024
+
+// redactOverlap is how much of a file before and after the part of it which is read is also
+// read, so that secrets which cross the edges of the part are still redacted.
+const redactOverlap = 16 * 1024
+
+// searchTimeout is how long search_files may walk the directory tree.
+const searchTimeout = 30 * time.Second
+
 type readFileInput struct {
-	Path string `json:"path" jsonschema:"path to the file relative to root directory"`
+	Path     string `json:"path" jsonschema:"path to the file relative to the current directory, or an absolute path within the root directory"`
+	Offset   int    `json:"offset,omitempty" jsonschema:"byte offset in the content to start at"`
+	MaxBytes int    `json:"maxBytes,omitempty" jsonschema:"maximum number of bytes of content to return"`
 }
 
 type readFileOutput struct {
-	Content string `json:"content" jsonschema:"the file contents"`
-	Size    int    `json:"size" jsonschema:"size of the file in bytes"`
-	Path    string `json:"path" jsonschema:"the path that was read"`
+	Content    string `json:"content" jsonschema:"the file contents, with secrets redacted"`
+	Size       int    `json:"size" jsonschema:"size of the file in bytes"`
+	Path       string `json:"path" jsonschema:"the path that was read"`
+	RelPath    string `json:"relPath,omitempty" jsonschema:"the path relative to the current directory, if it is not the root directory"`
+	Redactions int    `json:"redactions,omitempty" jsonschema:"number of secrets which were redacted from the contents"`
+	Offset     int    `json:"offset,omitempty" jsonschema:"byte offset in the content where this part starts"`
+	truncation
 }
 
 func (ft fileTools) handleReadFile(ctx context.Context, req *mcp.CallToolRequest,
@@ -29,26 +62,163 @@ func (ft fileTools) handleReadFile(ctx context.Context, req *mcp.CallToolRequest
 
 	slog.Info("read file", "args", args)
 
-	cnt, err := ft.readFile(ctx, args.Path)
+	p, err := ft.resolvePath(args.Path)
 	if err != nil {
 		return nil, readFileOutput{}, err
 	}
+	args.Path = p
 
-	return nil, readFileOutput{
-		Content: string(cnt),
-		Size:    len(cnt),
-		Path:    args.Path,
-	}, nil
+	err = ft.checkAllowed(ctx, args.Path)
+	if err != nil {
+		return nil, readFileOutput{}, err
+	}
+
+	out, err := ft.readWindow(ctx, args.Path, args.Offset,
+		limitMax(ft.maxBytes, args.MaxBytes))
+	if err != nil {
+		return nil, readFileOutput{}, ft.notFound(ctx, args.Path, err, ft.allowed)
+	}
+	if out.Redactions > 0 {
+		slog.Info("redacted secrets", "path", args.Path, "redactions", out.Redactions)
+	}
+	out.Path = args.Path
+	out.RelPath = ft.cwdRelative(args.Path)
+	return nil, out, nil
 }
 
-func (ft fileTools) readFile(ctx context.Context, path string) ([]byte, error) {
+// readWindow returns the content of the file at path from offset, and at most maxBytes of
+// it, with secrets redacted; only that part of the file, and redactOverlap before and after
+// it, is read. The content is cut at UTF-8 character boundaries, and it starts before and
+// ends after any secret which it would cut in two. Offsets are in the file.
+func (ft fileTools) readWindow(ctx context.Context, path string, offset,
+	maxBytes int) (readFileOutput, error) {
+
+	if offset < 0 {
+		return readFileOutput{}, fmt.Errorf("read_file: %w", errNegativeOffset)
+	}
+	if maxBytes <= 0 || maxBytes > maxFileSize {
+		maxBytes = maxFileSize
+	}
+
+	fh, err := ft.fs.Open(path)
+	if err != nil {
+		return readFileOutput{}, err
+	}
+	defer fh.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return readFileOutput{}, err
+	}
+	size := int(fi.Size())
+	offset = min(offset, size)
+	start := max(0, offset-redactOverlap)
+	buf := make([]byte, min(size, offset+maxBytes+redactOverlap)-start)
+	n, err := readAt(fh, buf, start)
+	auditBytes(ctx, n)
+	if err != nil && err != io.EOF {
+		return readFileOutput{}, err
+	}
+	buf = buf[:n]
+
+	lo := min(offset-start, len(buf))
+	for lo > 0 && lo < len(buf) && !utf8.RuneStart(buf[lo]) {
+		lo -= 1
+	}
+	hi := len(buf)
+	if lo+maxBytes < hi {
+		hi = lo + maxBytes
+		for hi > lo && !utf8.RuneStart(buf[hi]) {
+			hi -= 1
+		}
+		if hi == lo {
+			// A single character is longer than maxBytes.
+			_, n := utf8.DecodeRune(buf[lo:])
+			hi = lo + n
+		}
+	}
+
+	var found []redaction
+	for _, r := range ft.redactor.find(string(buf)) {
+		if r.start < lo && lo < r.end {
+			lo = r.start
+		}
+		if r.start < hi && hi < r.end {
+			hi = r.end
+		}
+		if lo <= r.start && r.end <= hi {
+			found = append(found, r)
+		}
+	}
+	for i := range found {
+		found[i].start -= lo
+		found[i].end -= lo
+	}
+
+	out := readFileOutput{
+		Content:    replace(string(buf[lo:hi]), found),
+		Size:       size,
+		Redactions: len(found),
+		Offset:     start + lo,
+	}
+	if next := start + hi; next < size {
+		out.truncation = truncation{
+			Truncated:  true,
+			Omitted:    size - next,
+			NextOffset: next,
+			Hint: fmt.Sprintf("%d bytes omitted: call read_file with offset %d to read the rest",
+				size-next, next),
+		}
+	}
+	return out, nil
+}
+
+// readAt reads len(buf) bytes of f from offset into buf.
+func readAt(f fs.File, buf []byte, offset int) (int, error) {
+	if ra, ok := f.(io.ReaderAt); ok {
+		return ra.ReadAt(buf, int64(offset))
+	}
+
+	_, err := io.CopyN(io.Discard, f, int64(offset))
+	if err == io.EOF {
+		return 0, io.EOF
+	} else if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// redact returns the contents of the file at path with secrets redacted, and the number of
+// secrets which were redacted.
+func (ft fileTools) redact(path string, cnt []byte) (string, int) {
+	content, redactions := ft.redactor.redact(string(cnt))
+	if redactions > 0 {
+		slog.Info("redacted secrets", "path", path, "redactions", redactions)
+	}
+	return content, redactions
+}
+
+// readFile returns the contents of the file at path, if it isn't larger than maxSize.
+func (ft fileTools) readFile(ctx context.Context, path string, maxSize int) ([]byte, error) {
 	fh, err := ft.fs.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer fh.Close()
 
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	} else if fi.Size() > int64(maxSize) {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: errTooLarge}
+	}
+
 	cnt, err := io.ReadAll(fh)
+	auditBytes(ctx, len(cnt))
 	if err != nil {
 		return nil, err
 	}
@@ -56,19 +226,45 @@ func (ft fileTools) readFile(ctx context.Context, path string) ([]byte, error) {
 }
 
 type listDirectoryInput struct {
-	Path string `json:"path,omitempty" jsonschema:"path to directory relative to root (empty for root)"`
+	Path     string `json:"path,omitempty" jsonschema:"path to the directory relative to the current directory, or an absolute path within the root directory (empty for the current directory)"`
+	Offset   int    `json:"offset,omitempty" jsonschema:"index of the first entry to return"`
+	MaxItems int    `json:"maxItems,omitempty" jsonschema:"maximum number of entries to return"`
 }
 
 type directoryEntry struct {
 	Name  string `json:"name" jsonschema:"name of the file or directory"`
 	Size  int64  `json:"size" jsonschema:"size in bytes (0 for directories)"`
 	IsDir bool   `json:"isDir" jsonschema:"true if this is a directory"`
+	symlink
+}
+
+// symlink reports a symbolic link rather than following it.
+type symlink struct {
+	IsSymlink bool   `json:"isSymlink,omitempty" jsonschema:"true if this is a symbolic link"`
+	Target    string `json:"target,omitempty" jsonschema:"the target of the symbolic link"`
+	Blocked   bool   `json:"blocked,omitempty" jsonschema:"true if the symbolic link is not followed because of the symlink policy"`
+}
+
+func (ft fileTools) symlink(p string, mode fs.FileMode) symlink {
+	if mode&fs.ModeSymlink == 0 {
+		return symlink{}
+	}
+
+	target, followed := ft.symlinkInfo(p)
+	return symlink{
+		IsSymlink: true,
+		Target:    target,
+		Blocked:   !followed,
+	}
 }
 
 type listDirectoryOutput struct {
 	Path    string           `json:"path" jsonschema:"the directory path that was listed"`
+	RelPath string           `json:"relPath,omitempty" jsonschema:"the directory path relative to the current directory, if it is not the root directory"`
 	Entries []directoryEntry `json:"entries" jsonschema:"list of directory entries"`
-	Count   int              `json:"count" jsonschema:"number of entries"`
+	Count   int              `json:"count" jsonschema:"number of entries returned"`
+	Total   int              `json:"total" jsonschema:"number of entries in the directory"`
+	truncation
 }
 
 func (ft fileTools) handleListDirectory(ctx context.Context, req *mcp.CallToolRequest,
@@ -76,23 +272,48 @@ func (ft fileTools) handleListDirectory(ctx context.Context, req *mcp.CallToolRe
 
 	slog.Info("list directory", "args", args)
 
+	p, err := ft.resolvePath(args.Path)
+	if err != nil {
+		return nil, listDirectoryOutput{}, err
+	}
+	args.Path = p
+
+	err = ft.checkVisible(ctx, args.Path)
+	if err != nil {
+		return nil, listDirectoryOutput{}, err
+	}
+
 	entries, err := ft.listDirectory(ctx, args.Path)
 	if err != nil {
 		return nil, listDirectoryOutput{}, err
 	}
+	realDir := ft.realPath(args.Path)
+	entries = slices.DeleteFunc(entries, func(de directoryEntry) bool {
+		return !ft.visible(path.Join(args.Path, de.Name)) ||
+			!ft.visible(path.Join(realDir, de.Name))
+	})
 
+	total := len(entries)
+	entries, trunc, err := truncateItems("list_directory", entries, args.Offset,
+		limitMax(ft.maxItems, args.MaxItems))
+	if err != nil {
+		return nil, listDirectoryOutput{}, err
+	}
 	return nil, listDirectoryOutput{
-		Path:    args.Path,
-		Entries: entries,
-		Count:   len(entries),
+		Path:       args.Path,
+		RelPath:    ft.cwdRelative(args.Path),
+		Entries:    entries,
+		Count:      len(entries),
+		Total:      total,
+		truncation: trunc,
 	}, nil
 }
 
-func (ft fileTools) listDirectory(ctx context.Context, path string) ([]directoryEntry, error) {
-	if path == "" {
-		path = "."
+func (ft fileTools) listDirectory(ctx context.Context, dir string) ([]directoryEntry, error) {
+	if dir == "" {
+		dir = "."
 	}
-	lst, err := fs.ReadDir(ft.fs, path)
+	lst, err := fs.ReadDir(ft.fs, dir)
 	if err != nil {
 		return nil, err
 	}
@@ -107,9 +328,10 @@ func (ft fileTools) listDirectory(ctx context.Context, path string) ([]directory
 		}
 
 		entries = append(entries, directoryEntry{
-			Name:  de.Name(),
-			Size:  sz,
-			IsDir: de.IsDir(),
+			Name:    de.Name(),
+			Size:    sz,
+			IsDir:   de.IsDir(),
+			symlink: ft.symlink(path.Join(dir, de.Name()), de.Type()),
 		})
 	}
 
@@ -117,13 +339,18 @@ func (ft fileTools) listDirectory(ctx context.Context, path string) ([]directory
 }
 
 type searchFilesInput struct {
-	Pattern string `json:"pattern" jsonschema:"glob pattern to match files, e.g. '*.txt'"`
+	Pattern  string `json:"pattern" jsonschema:"glob pattern to match files, e.g. '*.txt'"`
+	Offset   int    `json:"offset,omitempty" jsonschema:"index of the first match to return"`
+	MaxItems int    `json:"maxItems,omitempty" jsonschema:"maximum number of matches to return"`
 }
 
 type searchFilesOutput struct {
-	Pattern string   `json:"pattern" jsonschema:"the pattern that was searched"`
-	Matches []string `json:"matches" jsonschema:"list of matching file paths"`
-	Count   int      `json:"count" jsonschema:"number of matches found"`
+	Pattern    string   `json:"pattern" jsonschema:"the pattern that was searched"`
+	Matches    []string `json:"matches" jsonschema:"list of matching file paths"`
+	RelMatches []string `json:"relMatches,omitempty" jsonschema:"list of matching file paths relative to the current directory, if it is not the root directory"`
+	Count      int      `json:"count" jsonschema:"number of matches returned"`
+	Total      int      `json:"total" jsonschema:"number of matches found"`
+	truncation
 }
 
 func (ft fileTools) handleSearchFiles(ctx context.Context, req *mcp.CallToolRequest,
@@ -131,25 +358,58 @@ func (ft fileTools) handleSearchFiles(ctx context.Context, req *mcp.CallToolRequ
 
 	slog.Info("search files", "args", args)
 
-	matches, err := ft.searchFiles(ctx, args.Pattern)
+	err := ft.roots.wait(ctx)
+	if err != nil {
+		return nil, searchFilesOutput{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+	matches, err := ft.searchFiles(ctx, ft.cwd.get(), args.Pattern)
+	if err != nil {
+		return nil, searchFilesOutput{}, err
+	}
+
+	total := len(matches)
+	matches, trunc, err := truncateItems("search_files", matches, args.Offset,
+		limitMax(ft.maxItems, args.MaxItems))
 	if err != nil {
 		return nil, searchFilesOutput{}, err
 	}
 
+	var relMatches []string
+	if ft.cwd.get() != "." {
+		for _, m := range matches {
+			relMatches = append(relMatches, ft.cwdRelative(m))
+		}
+	}
 	return nil, searchFilesOutput{
-		Pattern: args.Pattern,
-		Matches: matches,
-		Count:   len(matches),
+		Pattern:    args.Pattern,
+		Matches:    matches,
+		RelMatches: relMatches,
+		Count:      len(matches),
+		Total:      total,
+		truncation: trunc,
 	}, nil
 }
 
-func (ft fileTools) searchFiles(ctx context.Context, pattern string) ([]string, error) {
+// searchFiles returns the files in dir and below which match pattern and which the session
+// may use. The search stops when ctx is done.
+func (ft fileTools) searchFiles(ctx context.Context, dir, pattern string) ([]string, error) {
 	var matches []string
-	err := fs.WalkDir(ft.fs, ".", func(path string, de fs.DirEntry, err error) error {
+	realDir := ft.realPath(dir)
+	err := fs.WalkDir(ft.fs, dir, func(path string, de fs.DirEntry, err error) error {
 		if err != nil {
 			return err
+		} else if err := ctx.Err(); err != nil {
+			return err
 		}
-		if de.IsDir() {
+		if ft.deny.denied(path) || ft.deny.denied(linkedPath(path, dir, realDir)) {
+			if de.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		} else if de.IsDir() {
 			return nil
 		}
 
@@ -158,7 +418,9 @@ func (ft fileTools) searchFiles(ctx context.Context, pattern string) ([]string,
 			return err
 		}
 
-		if matched {
+		if matched && ft.allowed(path) && ft.allowed(linkedPath(path, dir, realDir)) &&
+			(de.Type()&fs.ModeSymlink == 0 || ft.allowed(ft.realPath(path))) {
+
 			matches = append(matches, path)
 		}
 		return nil
@@ -171,15 +433,17 @@ func (ft fileTools) searchFiles(ctx context.Context, pattern string) ([]string,
 }
 
 type getFileInfoInput struct {
-	Path string `json:"path" jsonschema:"path to the file relative to root directory"`
+	Path string `json:"path" jsonschema:"path to the file relative to the current directory, or an absolute path within the root directory"`
 }
 
 type getFileInfoOutput struct {
 	Path    string `json:"path" jsonschema:"the file path"`
+	RelPath string `json:"relPath,omitempty" jsonschema:"the file path relative to the current directory, if it is not the root directory"`
 	Size    int64  `json:"size" jsonschema:"size in bytes"`
 	IsDir   bool   `json:"isDir" jsonschema:"true if this is a directory"`
 	ModTime string `json:"modTime" jsonschema:"last modification time"`
 	Mode    string `json:"mode" jsonschema:"file permissions"`
+	symlink
 }
 
 func (ft fileTools) handleGetFileInfo(ctx context.Context, req *mcp.CallToolRequest,
@@ -187,41 +451,176 @@ func (ft fileTools) handleGetFileInfo(ctx context.Context, req *mcp.CallToolRequ
 
 	slog.Info("get file info", "args", args)
 
-	fi, err := ft.getFileInfo(ctx, args.Path)
+	p, err := ft.resolvePath(args.Path)
+	if err != nil {
+		return nil, getFileInfoOutput{}, err
+	}
+	args.Path = p
+
+	err = ft.checkVisible(ctx, args.Path)
 	if err != nil {
 		return nil, getFileInfoOutput{}, err
 	}
+
+	fi, err := ft.getFileInfo(ctx, args.Path)
+	if err != nil {
+		return nil, getFileInfoOutput{}, ft.notFound(ctx, args.Path, err, ft.visible)
+	}
 	return nil, getFileInfoOutput{
 		Path:    args.Path,
+		RelPath: ft.cwdRelative(args.Path),
 		Size:    fi.Size(),
 		IsDir:   fi.IsDir(),
 		ModTime: fi.ModTime().Format("2006-01-02T15:04:05Z07:00"),
 		Mode:    fi.Mode().String(),
+		symlink: ft.symlink(args.Path, fi.Mode()),
 	}, nil
 }
 
+// getFileInfo returns the file info of path; symbolic links are not followed.
 func (ft fileTools) getFileInfo(ctx context.Context, path string) (fs.FileInfo, error) {
-	return fs.Stat(ft.fs, path)
+	return lstat(ft.fs, path)
 }
 
+type listRootsInput struct{}
+
+type rootEntry struct {
+	Name       string `json:"name" jsonschema:"name of the root, which is the first element of paths in the root (empty if there is only one root)"`
+	Permission string `json:"permission" jsonschema:"read-only or read-write"`
+}
+
+type listRootsOutput struct {
+	Roots []rootEntry `json:"roots" jsonschema:"list of roots"`
+	Count int         `json:"count" jsonschema:"number of roots"`
+}
+
+func (ft fileTools) handleListRoots(ctx context.Context, req *mcp.CallToolRequest,
+	args listRootsInput) (*mcp.CallToolResult, listRootsOutput, error) {
+
+	slog.Info("list roots")
+
+	err := ft.roots.wait(ctx)
+	if err != nil {
+		return nil, listRootsOutput{}, err
+	}
+
+	roots := ft.listRoots(ctx)
+	return nil, listRootsOutput{
+		Roots: roots,
+		Count: len(roots),
+	}, nil
+}
+
+// listRoots returns the server roots which the session may use some of. The directories of
+// the roots are not returned, so that paths on the server are not revealed.
+func (ft fileTools) listRoots(ctx context.Context) []rootEntry {
+	roots := []rootEntry{}
+	for _, sr := range ft.serverRoots {
+		p := sr.name
+		if p == "" {
+			p = "."
+		}
+		if !ft.visible(p) {
+			continue
+		}
+
+		roots = append(roots, rootEntry{
+			Name:       sr.name,
+			Permission: sr.perm.String(),
+		})
+	}
+	return roots
+}
+
+// serverTool is a tool which can be added to a server.
+type serverTool struct {
+	name string
+	add  func(srvr *mcp.Server)
+}
+
+// newServerTool returns a tool which calls h. The error which h fails with, if any, is
+// recorded, so that it can be reported as a tool error.
+func newServerTool[In, Out any](t *mcp.Tool, h mcp.ToolHandlerFor[In, Out]) serverTool {
+	return serverTool{
+		name: t.Name,
+		add: func(srvr *mcp.Server) {
+			mcp.AddTool(srvr, t, func(ctx context.Context, req *mcp.CallToolRequest,
+				args In) (*mcp.CallToolResult, Out, error) {
+
+				res, out, err := h(ctx, req, args)
+				if err != nil {
+					setCallError(ctx, err)
+				}
+				return res, out, err
+			})
+		},
+	}
+}
+
+// registerTools adds the tools which the session is authorized to use to srvr, and removes
+// the tools which it no longer is authorized to use. The server notifies the session when
+// its tools change.
 func (ft fileTools) registerTools(srvr *mcp.Server) {
-	mcp.AddTool(srvr, &mcp.Tool{
-		Name:        "read_file",
-		Description: "Read the contents of a file. Returns the file content as text.",
-	}, ft.handleReadFile)
-
-	mcp.AddTool(srvr, &mcp.Tool{
-		Name:        "list_directory",
-		Description: "List the contents of a directory. Returns file names, types, and sizes.",
-	}, ft.handleListDirectory)
-
-	mcp.AddTool(srvr, &mcp.Tool{
-		Name:        "search_files",
-		Description: "Search for files matching a glob pattern (e.g., '*.go', 'test*', '*.md').",
-	}, ft.handleSearchFiles)
-
-	mcp.AddTool(srvr, &mcp.Tool{
-		Name:        "get_file_info",
-		Description: "Get detailed information about a file or directory.",
-	}, ft.handleGetFileInfo)
+	ft.access.mu.Lock()
+	defer ft.access.mu.Unlock()
+
+	var remove []string
+	for _, st := range ft.tools() {
+		allowed := ft.access.access.allowTool(st.name) && allowScope(ft.session.scopes, st.name)
+		registered := slices.Contains(ft.access.tools, st.name)
+		if allowed && !registered {
+			st.add(srvr)
+			ft.access.tools = append(ft.access.tools, st.name)
+		} else if !allowed && registered {
+			remove = append(remove, st.name)
+		}
+	}
+
+	if len(remove) > 0 {
+		srvr.RemoveTools(remove...)
+		ft.access.tools = slices.DeleteFunc(ft.access.tools, func(name string) bool {
+			return slices.Contains(remove, name)
+		})
+	}
+}
+
+func (ft fileTools) tools() []serverTool {
+	return []serverTool{
+		newServerTool(&mcp.Tool{
+			Name: "read_file",
+			Description: "Read the contents of a file. Returns the file content as text; " +
+				"large files are returned in parts.",
+		}, ft.handleReadFile),
+		newServerTool(&mcp.Tool{
+			Name:        "list_directory",
+			Description: "List the contents of a directory. Returns file names, types, and sizes.",
+		}, ft.handleListDirectory),
+		newServerTool(&mcp.Tool{
+			Name: "search_files",
+			Description: "Search for files in the current directory and below matching a glob " +
+				"pattern (e.g., '*.go', 'test*', '*.md').",
+		}, ft.handleSearchFiles),
+		newServerTool(&mcp.Tool{
+			Name:        "get_file_info",
+			Description: "Get detailed information about a file or directory.",
+		}, ft.handleGetFileInfo),
+		newServerTool(&mcp.Tool{
+			Name:        "list_roots",
+			Description: "List the root directories being served and their permissions.",
+		}, ft.handleListRoots),
+		newServerTool(&mcp.Tool{
+			Name: "cd",
+			Description: "Change the current directory of the session. Relative paths are " +
+				"resolved against the current directory.",
+		}, ft.handleCd),
+		newServerTool(&mcp.Tool{
+			Name:        "pwd",
+			Description: "Return the current directory of the session.",
+		}, ft.handlePwd),
+		newServerTool(&mcp.Tool{
+			Name: "summarize_file",
+			Description: "Summarize a file which is too large to read, using the client's model. " +
+				"Summaries are cached until the file changes.",
+		}, ft.handleSummarizeFile),
+	}
 }
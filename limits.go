@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// limit is a token bucket, which allows Rate calls per second on average and up to Burst
+// calls at once, and a maximum number of Concurrent calls. Zero means unlimited.
+type limit struct {
+	Rate       float64 `json:"rate,omitempty"`
+	Burst      int     `json:"burst,omitempty"`
+	Concurrent int     `json:"concurrent,omitempty"`
+}
+
+func (l limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, l.Rate)
+}
+
+// toolLimits maps tools to their limit. The limit of "*" is shared by the tools which are
+// not otherwise listed.
+type toolLimits map[string]limit
+
+// lookup returns the limit of tool, and the name of its bucket: the tool or "*".
+func (tl toolLimits) lookup(tool string) (limit, string, bool) {
+	if l, ok := tl[tool]; ok {
+		return l, tool, true
+	} else if l, ok := tl["*"]; ok {
+		return l, "*", true
+	}
+	return limit{}, "", false
+}
+
+// limitPolicy is the limits of tool calls for each session and for each identity, across
+// all of its sessions. Sessions which are not authenticated, such as the stdio session, all
+// have the identity "", so they share its limits. A nil limitPolicy doesn't limit anything.
+type limitPolicy struct {
+	Session  toolLimits `json:"session,omitempty"`
+	Identity toolLimits `json:"identity,omitempty"`
+}
+
+func parseLimitPolicy(cnt []byte) (*limitPolicy, error) {
+	if len(cnt) == 0 {
+		return nil, nil
+	}
+
+	var lp limitPolicy
+	dec := json.NewDecoder(bytes.NewReader(cnt))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&lp)
+	if err != nil {
+		return nil, fmt.Errorf("limits: %s", err)
+	}
+
+	for _, tl := range []toolLimits{lp.Session, lp.Identity} {
+		for tool, l := range tl {
+			if l.Rate < 0 || l.Burst < 0 || l.Concurrent < 0 {
+				return nil, fmt.Errorf("limits: %s: must not be negative", tool)
+			}
+		}
+	}
+	return &lp, nil
+}
+
+// limitState is the state of a bucket.
+type limitState struct {
+	tokens float64
+	last   time.Time
+	calls  int
+}
+
+// limiter enforces a limit policy. The state of the buckets of each identity, including the
+// identity "" shared by every unauthenticated session, is kept by the limiter; the state of
+// the buckets of each session is kept by its middleware.
+type limiter struct {
+	mu         sync.Mutex
+	policy     *limitPolicy
+	identities map[string]map[string]*limitState
+	swept      time.Time
+}
+
+func newLimiter(lp *limitPolicy) *limiter {
+	return &limiter{
+		policy:     lp,
+		identities: map[string]map[string]*limitState{},
+	}
+}
+
+// setPolicy replaces the limit policy; the buckets of identities start over.
+func (lim *limiter) setPolicy(lp *limitPolicy) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	lim.policy = lp
+	lim.identities = map[string]map[string]*limitState{}
+}
+
+var errRateLimit = errors.New("rate limit exceeded")
+
+// rateLimitError is the error of a call which is over a limit; it may be retried after
+// RetryAfter.
+type rateLimitError struct {
+	tool       string
+	reason     string
+	RetryAfter time.Duration
+}
+
+func (rle *rateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s: %s: retry after %s", rle.tool, errRateLimit, rle.reason,
+		rle.RetryAfter)
+}
+
+func (rle *rateLimitError) Unwrap() error {
+	return errRateLimit
+}
+
+// concurrentRetry is how long to suggest waiting before retrying a call which is over a
+// concurrency limit.
+const concurrentRetry = time.Second
+
+type limitCheck struct {
+	state *limitState
+	limit limit
+	scope string
+}
+
+// acquire takes a token from the session and identity buckets of tool, and counts a call in
+// flight, if it is within the limits; otherwise, it returns an error. release must be called
+// when the call finishes.
+func (lim *limiter) acquire(session map[string]*limitState, identity, tool string,
+	now time.Time) (func(), error) {
+
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+
+	if lim.policy == nil {
+		return func() {}, nil
+	}
+	lim.sweep(now)
+
+	var checks []limitCheck
+	if l, name, ok := lim.policy.Session.lookup(tool); ok {
+		checks = append(checks, limitCheck{bucket(session, name, l, now), l, "session"})
+	}
+	if l, name, ok := lim.policy.Identity.lookup(tool); ok {
+		buckets, ok := lim.identities[identity]
+		if !ok {
+			buckets = map[string]*limitState{}
+			lim.identities[identity] = buckets
+		}
+		checks = append(checks, limitCheck{bucket(buckets, name, l, now), l, "identity"})
+	}
+
+	for _, lc := range checks {
+		if lc.limit.Concurrent > 0 && lc.state.calls >= lc.limit.Concurrent {
+			return nil, &rateLimitError{
+				tool:       tool,
+				reason:     fmt.Sprintf("%d concurrent calls per %s", lc.limit.Concurrent, lc.scope),
+				RetryAfter: concurrentRetry,
+			}
+		}
+		if lc.limit.Rate > 0 {
+			lc.state.tokens = math.Min(lc.limit.burst(),
+				lc.state.tokens+now.Sub(lc.state.last).Seconds()*lc.limit.Rate)
+			lc.state.last = now
+			if lc.state.tokens < 1 {
+				wait := (1 - lc.state.tokens) / lc.limit.Rate
+				return nil, &rateLimitError{
+					tool:       tool,
+					reason:     fmt.Sprintf("%g calls per second per %s", lc.limit.Rate, lc.scope),
+					RetryAfter: time.Duration(wait * float64(time.Second)).Round(time.Millisecond),
+				}
+			}
+		}
+	}
+
+	for _, lc := range checks {
+		if lc.limit.Rate > 0 {
+			lc.state.tokens -= 1
+		}
+		lc.state.calls += 1
+	}
+	return func() {
+		lim.mu.Lock()
+		defer lim.mu.Unlock()
+
+		for _, lc := range checks {
+			lc.state.calls -= 1
+		}
+	}, nil
+}
+
+// sweepInterval is how often the buckets of idle identities are removed.
+const sweepInterval = time.Minute
+
+// sweep removes the buckets of identities which have no calls in flight and whose buckets
+// are full, so that they are the same as new buckets.
+func (lim *limiter) sweep(now time.Time) {
+	if now.Sub(lim.swept) < sweepInterval {
+		return
+	}
+	lim.swept = now
+
+	for identity, buckets := range lim.identities {
+		idle := true
+		for name, ls := range buckets {
+			l := lim.policy.Identity[name]
+			if ls.calls > 0 ||
+				(l.Rate > 0 && ls.tokens+now.Sub(ls.last).Seconds()*l.Rate < l.burst()) {
+
+				idle = false
+				break
+			}
+		}
+		if idle {
+			delete(lim.identities, identity)
+		}
+	}
+}
+
+func bucket(buckets map[string]*limitState, name string, l limit, now time.Time) *limitState {
+	ls, ok := buckets[name]
+	if !ok {
+		ls = &limitState{tokens: l.burst(), last: now}
+		buckets[name] = ls
+	}
+	return ls
+}
+
+// middleware returns middleware which refuses the tool calls of the session which are over
+// its limits, or the limits of its identity, with an error result which says when to retry.
+func (lim *limiter) middleware(si sessionInfo) mcp.Middleware {
+	session := map[string]*limitState{}
+
+	return func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			ctr, ok := req.(*mcp.CallToolRequest)
+			if !ok || method != "tools/call" {
+				return next(ctx, method, req)
+			}
+
+			release, err := lim.acquire(session, si.identity, ctr.Params.Name, time.Now())
+			if err != nil {
+				slog.Info("rate limited", "tool", ctr.Params.Name, "identity", si.identity,
+					"error", err)
+				auditError(ctx, err)
+
+				var rle *rateLimitError
+				errors.As(err, &rle)
+				te := newToolError(err, nil)
+				te.RetryAfter = rle.RetryAfter.Seconds()
+				return te.result(), nil
+			}
+			defer release()
+
+			return next(ctx, method, req)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestParseLimitPolicy(t *testing.T) {
+	cases := []struct {
+		s    string
+		fail bool
+	}{
+		{s: ""},
+		{s: `{"session": {"*": {"rate": 10, "burst": 20, "concurrent": 4}}}`},
+		{s: `{"identity": {"search_files": {"rate": 0.5}}}`},
+		{s: `{"session": {"*": {"rate": -1}}}`, fail: true},
+		{s: `{"session": {"*": {"rate": 1, "per": "minute"}}}`, fail: true},
+		{s: `{"sessions": {}}`, fail: true},
+		{s: `[]`, fail: true},
+	}
+
+	for _, c := range cases {
+		_, err := parseLimitPolicy([]byte(c.s))
+		if c.fail {
+			if err == nil {
+				t.Errorf("parseLimitPolicy(%s) did not fail", c.s)
+			}
+		} else if err != nil {
+			t.Errorf("parseLimitPolicy(%s) failed with %s", c.s, err)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	lim := newLimiter(&limitPolicy{
+		Session: toolLimits{
+			"*":            {Rate: 10, Burst: 2},
+			"search_files": {Concurrent: 1},
+		},
+		Identity: toolLimits{
+			"read_file": {Rate: 1, Burst: 3},
+		},
+	})
+
+	now := time.Now()
+	session := map[string]*limitState{}
+	acquire := func(session map[string]*limitState, identity, tool string,
+		retryAfter time.Duration) func() {
+
+		t.Helper()
+
+		release, err := lim.acquire(session, identity, tool, now)
+		if retryAfter == 0 {
+			if err != nil {
+				t.Fatalf("acquire(%s, %s) failed with %s", identity, tool, err)
+			}
+			return release
+		}
+
+		var rle *rateLimitError
+		if err == nil {
+			t.Fatalf("acquire(%s, %s) did not fail", identity, tool)
+		} else if !errors.As(err, &rle) || !errors.Is(err, errRateLimit) {
+			t.Fatalf("acquire(%s, %s) failed with %s", identity, tool, err)
+		} else if rle.RetryAfter != retryAfter {
+			t.Errorf("acquire(%s, %s) got retry after %s, want %s", identity, tool,
+				rle.RetryAfter, retryAfter)
+		}
+		return nil
+	}
+
+	// The tools which are not listed share the "*" bucket.
+	acquire(session, "alice", "list_roots", 0)()
+	acquire(session, "alice", "get_file_info", 0)()
+	acquire(session, "alice", "list_roots", 100*time.Millisecond)
+	now = now.Add(50 * time.Millisecond)
+	acquire(session, "alice", "list_roots", 50*time.Millisecond)
+	now = now.Add(50 * time.Millisecond)
+	acquire(session, "alice", "list_roots", 0)()
+
+	// Concurrent calls.
+	release := acquire(session, "alice", "search_files", 0)
+	acquire(session, "alice", "search_files", concurrentRetry)
+	acquire(map[string]*limitState{}, "alice", "search_files", 0)()
+	release()
+	acquire(session, "alice", "search_files", 0)()
+
+	// The bucket of an identity is shared by its sessions.
+	acquire(map[string]*limitState{}, "alice", "read_file", 0)()
+	acquire(map[string]*limitState{}, "alice", "read_file", 0)()
+	acquire(map[string]*limitState{}, "alice", "read_file", 0)()
+	acquire(map[string]*limitState{}, "alice", "read_file", time.Second)
+	acquire(map[string]*limitState{}, "bob", "read_file", 0)()
+
+	// Reloading the policy starts the buckets of identities over.
+	lim.setPolicy(nil)
+	for i := 0; i < 10; i++ {
+		acquire(map[string]*limitState{}, "alice", "read_file", 0)()
+	}
+}
+
+func TestLimitTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("content"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		limiter: newLimiter(&limitPolicy{
+			Session: toolLimits{"read_file": {Rate: 0.1}},
+		}),
+	}
+
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "file.txt"}); res.IsError {
+		t.Errorf("read_file(file.txt) failed with %v", res.Content)
+	}
+	res := callTool(t, cs, "read_file", map[string]any{"path": "file.txt"})
+	if !res.IsError {
+		t.Fatalf("read_file(file.txt) was not rate limited")
+	}
+	out, ok := res.StructuredContent.(map[string]any)
+	if !ok || out["code"] != "rate_limited" {
+		t.Errorf("read_file(file.txt) got %v, want rate_limited", res.StructuredContent)
+	} else if retry, ok := out["retryAfter"].(float64); !ok || retry <= 9 || retry > 10 {
+		t.Errorf("read_file(file.txt) got retry after %v, want about 10", out["retryAfter"])
+	}
+
+	if res := callTool(t, cs, "list_roots", map[string]any{}); res.IsError {
+		t.Errorf("list_roots() failed with %v", res.Content)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	lim := newLimiter(&limitPolicy{
+		Identity: toolLimits{
+			"read_file":    {Rate: 0.01, Burst: 1},
+			"search_files": {Rate: 1, Burst: 1},
+			"*":            {Concurrent: 1},
+		},
+	})
+
+	now := time.Now()
+	var releases []func()
+	for _, c := range []struct {
+		identity string
+		tool     string
+		release  bool
+	}{
+		{identity: "alice", tool: "read_file", release: true},
+		{identity: "bob", tool: "list_roots", release: true},
+		{identity: "carol", tool: "list_roots"},
+		{identity: "dave", tool: "search_files", release: true},
+	} {
+		release, err := lim.acquire(map[string]*limitState{}, c.identity, c.tool, now)
+		if err != nil {
+			t.Fatalf("acquire(%s, %s) failed with %s", c.identity, c.tool, err)
+		}
+		if c.release {
+			release()
+		} else {
+			releases = append(releases, release)
+		}
+	}
+
+	lim.sweep(now.Add(sweepInterval / 2))
+	if len(lim.identities) != 4 {
+		t.Errorf("sweep() got %d identities, want 4", len(lim.identities))
+	}
+
+	// alice's bucket isn't full yet, and carol has a call in flight.
+	lim.sweep(now.Add(sweepInterval))
+	if len(lim.identities) != 2 {
+		t.Errorf("sweep() got %d identities, want alice and carol", len(lim.identities))
+	}
+	for _, identity := range []string{"alice", "carol"} {
+		if _, ok := lim.identities[identity]; !ok {
+			t.Errorf("sweep() removed %s", identity)
+		}
+	}
+
+	for _, release := range releases {
+		release()
+	}
+}
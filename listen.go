@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// loopbackAddr returns true if addr only listens on a loopback interface, so plain HTTP may
+// be used.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	} else if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// unixListener removes its socket when it is closed.
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (ul unixListener) Close() error {
+	err := ul.Listener.Close()
+	os.Remove(ul.path)
+	return err
+}
+
+// listenUnix listens on a Unix domain socket at path, which only users with mode access to
+// the socket may connect to. A stale socket, one which no server is listening on, is
+// replaced.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("%s: exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: socket is in use", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the socket in a private directory and set its mode before moving it into place,
+	// so that no one can connect before the mode is set.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(path), ".filemcp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "sock")
+	ln, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, mode)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return unixListener{Listener: ln, path: path}, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoopbackAddr(t *testing.T) {
+	cases := []struct {
+		addr     string
+		loopback bool
+	}{
+		{addr: "127.0.0.1:8080", loopback: true},
+		{addr: "127.1.2.3:8080", loopback: true},
+		{addr: "[::1]:8080", loopback: true},
+		{addr: "localhost:8080", loopback: true},
+		{addr: ":8080"},
+		{addr: "0.0.0.0:8080"},
+		{addr: "[::]:8080"},
+		{addr: "10.0.0.1:8080"},
+		{addr: "example.com:8080"},
+		{addr: "127.0.0.1"},
+	}
+
+	for _, c := range cases {
+		if loopback := loopbackAddr(c.addr); loopback != c.loopback {
+			t.Errorf("loopbackAddr(%s) got %v, want %v", c.addr, loopback, c.loopback)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	// Unix domain socket paths are limited in length, so don't use t.TempDir.
+	dir, err := os.MkdirTemp("", "filemcp")
+	if err != nil {
+		t.Fatalf("MkdirTemp() failed with %s", err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "filemcp.sock")
+
+	ln, err := listenUnix(sock, 0600)
+	if err != nil {
+		t.Fatalf("listenUnix(%s) failed with %s", sock, err)
+	}
+	if info, err := os.Stat(sock); err != nil {
+		t.Errorf("Stat(%s) failed with %s", sock, err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("Stat(%s) got %s, want 0600", sock, info.Mode().Perm())
+	}
+
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "hello")
+		}),
+	}
+	go httpServer.Serve(ln)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	resp, err := client.Get("http://filemcp/mcp")
+	if err != nil {
+		t.Fatalf("Get() failed with %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("Get() got %q, want hello", body)
+	}
+
+	_, err = listenUnix(sock, 0600)
+	if err == nil {
+		t.Errorf("listenUnix(%s) did not fail while in use", sock)
+	}
+
+	httpServer.Close()
+	if _, err := os.Lstat(sock); !os.IsNotExist(err) {
+		t.Errorf("Lstat(%s) after close got %v, want not exist", sock, err)
+	}
+
+	// A stale socket is replaced.
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Listen(%s) failed with %s", sock, err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err = listenUnix(sock, 0660)
+	if err != nil {
+		t.Fatalf("listenUnix(%s) failed with %s", sock, err)
+	}
+	if info, err := os.Stat(sock); err != nil {
+		t.Errorf("Stat(%s) failed with %s", sock, err)
+	} else if info.Mode().Perm() != 0660 {
+		t.Errorf("Stat(%s) got %s, want 0660", sock, info.Mode().Perm())
+	}
+	ln.Close()
+
+	file := filepath.Join(dir, "file")
+	mustWriteFile(t, file, []byte("file"))
+	_, err = listenUnix(file, 0600)
+	if err == nil {
+		t.Errorf("listenUnix(%s) did not fail for a file", file)
+	}
+}
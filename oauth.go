@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
+	"github.com/modelcontextprotocol/go-sdk/oauthex"
+)
+
+const (
+	scopeRead  = "files:read"
+	scopeWrite = "files:write"
+
+	protectedResourcePath = "/.well-known/oauth-protected-resource"
+
+	// clockSkew is how much the clocks of the issuer and the server may differ.
+	clockSkew = time.Minute
+
+	// jwksRefresh is how often a JWKS URL may be fetched again, to find a key which isn't
+	// in the key set.
+	jwksRefresh = time.Minute
+)
+
+// toolScopes maps tools to the scope which an access token must have to use them. Tools
+// which are not listed require scopeWrite.
+var toolScopes = map[string]string{
+	"read_file":      scopeRead,
+	"list_directory": scopeRead,
+	"search_files":   scopeRead,
+	"get_file_info":  scopeRead,
+	"list_roots":     scopeRead,
+	"summarize_file": scopeRead,
+	"cd":             scopeRead,
+	"pwd":            scopeRead,
+}
+
+// allowScope returns true if a session with scopes may use tool. Nil scopes allows every
+// tool: the session was not authorized with an access token.
+func allowScope(scopes []string, tool string) bool {
+	if scopes == nil {
+		return true
+	}
+
+	scope, ok := toolScopes[tool]
+	if !ok {
+		scope = scopeWrite
+	}
+	return slices.Contains(scopes, scope)
+}
+
+// jsonWebKey is a public key from a JSON Web Key Set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type verifyKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	} else if len(b) == 0 {
+		return nil, fmt.Errorf("missing value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %s", err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %s", err)
+		} else if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("e: too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var crv elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			crv = elliptic.P256()
+		case "P-384":
+			crv = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %q", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %s", err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %s", err)
+		}
+		if !crv.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: crv, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %s", err)
+		} else if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("x: wrong size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %q", jwk.Kty)
+}
+
+// parseJWKS parses a JSON Web Key Set. Keys which are not for signing, or which are not
+// supported, are skipped.
+func parseJWKS(cnt []byte) ([]verifyKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := json.Unmarshal(cnt, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %s", err)
+	}
+
+	var keys []verifyKey
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			slog.Warn("skipping jwks key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys = append(keys, verifyKey{
+			kid: jwk.Kid,
+			alg: jwk.Alg,
+			key: key,
+		})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks: no usable keys")
+	}
+	return keys, nil
+}
+
+// jwtVerifier validates JWT access tokens issued for a resource.
+type jwtVerifier struct {
+	jwks     string
+	issuer   string
+	resource string
+	client   *http.Client
+
+	mu      sync.Mutex
+	keys    []verifyKey
+	fetched time.Time
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// newJWTVerifier returns a verifier of tokens for resource, signed by one of the keys in
+// jwks, a file or an URL. If issuer is not empty, tokens must be issued by it.
+func newJWTVerifier(jwks, issuer, resource string) (*jwtVerifier, error) {
+	if resource == "" {
+		return nil, fmt.Errorf("jwks: resource is required")
+	} else if _, err := url.Parse(resource); err != nil {
+		return nil, fmt.Errorf("jwks: bad resource: %s", err)
+	}
+
+	jv := &jwtVerifier{
+		jwks:     jwks,
+		issuer:   issuer,
+		resource: resource,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	err := jv.loadKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return jv, nil
+}
+
+func (jv *jwtVerifier) loadKeys(ctx context.Context) error {
+	var cnt []byte
+	if isURL(jv.jwks) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, jv.jwks, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := jv.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", jv.jwks, resp.Status)
+		}
+		cnt, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return err
+		}
+	} else {
+		var err error
+		cnt, err = os.ReadFile(jv.jwks)
+		if err != nil {
+			return err
+		}
+	}
+
+	keys, err := parseJWKS(cnt)
+	if err != nil {
+		return err
+	}
+
+	jv.mu.Lock()
+	jv.keys = keys
+	jv.fetched = time.Now()
+	jv.mu.Unlock()
+	return nil
+}
+
+// findKey returns the key to verify a token signed with alg by kid. If the key is not found
+// and the keys are from an URL, they are fetched again, in case the issuer rotated its keys.
+func (jv *jwtVerifier) findKey(ctx context.Context, alg, kid string) (crypto.PublicKey, bool) {
+	jv.mu.Lock()
+	key, ok := lookupKey(jv.keys, alg, kid)
+	refresh := !ok && isURL(jv.jwks) && time.Since(jv.fetched) > jwksRefresh
+	if refresh {
+		jv.fetched = time.Now()
+	}
+	jv.mu.Unlock()
+
+	if refresh {
+		err := jv.loadKeys(ctx)
+		if err != nil {
+			slog.Warn("refresh jwks", "jwks", jv.jwks, "error", err)
+			return nil, false
+		}
+
+		jv.mu.Lock()
+		key, ok = lookupKey(jv.keys, alg, kid)
+		jv.mu.Unlock()
+	}
+	return key, ok
+}
+
+func lookupKey(keys []verifyKey, alg, kid string) (crypto.PublicKey, bool) {
+	for _, vk := range keys {
+		if (kid == "" || vk.kid == kid) && (vk.alg == "" || vk.alg == alg) &&
+			keyAlgorithm(vk.key, alg) {
+
+			return vk.key, true
+		}
+	}
+	return nil, false
+}
+
+// keyAlgorithm returns true if key can verify signatures made with alg.
+func keyAlgorithm(key crypto.PublicKey, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" || alg == "RS384" || alg == "RS512"
+	case *ecdsa.PublicKey:
+		return (alg == "ES256" && k.Curve == elliptic.P256()) ||
+			(alg == "ES384" && k.Curve == elliptic.P384())
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+func verifySignature(key crypto.PublicKey, alg string, signed, sig []byte) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		var h crypto.Hash
+		var digest []byte
+		switch alg {
+		case "RS256":
+			h = crypto.SHA256
+			d := sha256.Sum256(signed)
+			digest = d[:]
+		case "RS384":
+			h = crypto.SHA384
+			d := sha512.Sum384(signed)
+			digest = d[:]
+		case "RS512":
+			h = crypto.SHA512
+			d := sha512.Sum512(signed)
+			digest = d[:]
+		default:
+			return false
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch alg {
+		case "ES256":
+			d := sha256.Sum256(signed)
+			digest = d[:]
+		case "ES384":
+			d := sha512.Sum384(signed)
+			digest = d[:]
+		default:
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, signed, sig)
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	Expires   *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	ClientID  string          `json:"client_id"`
+	Scope     string          `json:"scope"`
+	Scp       []string        `json:"scp"`
+}
+
+func (jc jwtClaims) audiences() []string {
+	var aud string
+	if json.Unmarshal(jc.Audience, &aud) == nil {
+		return []string{aud}
+	}
+	var auds []string
+	json.Unmarshal(jc.Audience, &auds)
+	return auds
+}
+
+func (jc jwtClaims) scopes() []string {
+	scopes := strings.Fields(jc.Scope)
+	scopes = append(scopes, jc.Scp...)
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return scopes
+}
+
+func invalidToken(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", auth.ErrInvalidToken, fmt.Sprintf(format, args...))
+}
+
+// verify validates token, and returns its subject, scopes, and expiration.
+func (jv *jwtVerifier) verify(ctx context.Context, token string) (*auth.TokenInfo, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("malformed token")
+	}
+
+	var hdr jwtHeader
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &hdr) != nil {
+		return nil, invalidToken("malformed header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidToken("malformed signature")
+	}
+
+	key, ok := jv.findKey(ctx, hdr.Alg, hdr.Kid)
+	if !ok {
+		return nil, invalidToken("no key for alg %q and kid %q", hdr.Alg, hdr.Kid)
+	}
+	if !verifySignature(key, hdr.Alg, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, invalidToken("bad signature")
+	}
+
+	var claims jwtClaims
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(b, &claims) != nil {
+		return nil, invalidToken("malformed claims")
+	}
+
+	now := time.Now()
+	if jv.issuer != "" && claims.Issuer != jv.issuer {
+		return nil, invalidToken("wrong issuer: %q", claims.Issuer)
+	} else if !slices.Contains(claims.audiences(), jv.resource) {
+		return nil, invalidToken("wrong audience")
+	} else if claims.Expires == nil {
+		return nil, invalidToken("missing expiration")
+	}
+	exp := time.Unix(int64(*claims.Expires), 0)
+	if now.After(exp.Add(clockSkew)) {
+		return nil, invalidToken("expired")
+	} else if claims.NotBefore != nil &&
+		now.Add(clockSkew).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+
+		return nil, invalidToken("not yet valid")
+	}
+
+	userID := claims.Subject
+	if userID == "" {
+		userID = claims.ClientID
+	}
+	return &auth.TokenInfo{
+		Scopes:     claims.scopes(),
+		Expiration: exp.Add(clockSkew),
+		UserID:     userID,
+	}, nil
+}
+
+// resourceMetadataURL returns the URL of the protected resource metadata of resource.
+func resourceMetadataURL(resource string) string {
+	u, err := url.Parse(resource)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   protectedResourcePath + strings.TrimSuffix(u.Path, "/"),
+	}).String()
+}
+
+// requireJWT returns middleware which requires each request to have a valid access token as
+// a bearer token. The subject of the token is the identity of the request.
+func (jv *jwtVerifier) requireJWT() func(http.Handler) http.Handler {
+	return auth.RequireBearerToken(
+		func(ctx context.Context, token string, r *http.Request) (*auth.TokenInfo, error) {
+			ti, err := jv.verify(ctx, token)
+			if err != nil {
+				slog.Info("invalid access token", "remote", r.RemoteAddr, "error", err)
+				return nil, err
+			}
+			return ti, nil
+		}, &auth.RequireBearerTokenOptions{
+			ResourceMetadataURL: resourceMetadataURL(jv.resource),
+		})
+}
+
+// metadataHandler returns a handler which serves the protected resource metadata.
+func (jv *jwtVerifier) metadataHandler() http.Handler {
+	prm := &oauthex.ProtectedResourceMetadata{
+		Resource:               jv.resource,
+		ScopesSupported:        []string{scopeRead, scopeWrite},
+		BearerMethodsSupported: []string{"header"},
+		ResourceName:           "filemcp",
+	}
+	if jv.issuer != "" {
+		prm.AuthorizationServers = []string{jv.issuer}
+	}
+	return auth.ProtectedResourceMetadataHandler(prm)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/modelcontextprotocol/go-sdk/oauthex"
+)
+
+type testIssuer struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	edKey  ed25519.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed with %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed with %s", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed with %s", err)
+	}
+	return &testIssuer{
+		rsaKey: rsaKey,
+		ecKey:  ecKey,
+		edKey:  edKey,
+	}
+}
+
+func encodeBigInt(n *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+}
+
+func (ti *testIssuer) jwks(kids ...string) []byte {
+	var keys []jsonWebKey
+	for _, kid := range kids {
+		switch kid {
+		case "rsa":
+			keys = append(keys, jsonWebKey{
+				Kty: "RSA",
+				Kid: kid,
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(ti.rsaKey.N.Bytes()),
+				E:   encodeBigInt(big.NewInt(int64(ti.rsaKey.E)), 3),
+			})
+		case "ec":
+			keys = append(keys, jsonWebKey{
+				Kty: "EC",
+				Kid: kid,
+				Crv: "P-256",
+				X:   encodeBigInt(ti.ecKey.X, 32),
+				Y:   encodeBigInt(ti.ecKey.Y, 32),
+			})
+		case "ed":
+			keys = append(keys, jsonWebKey{
+				Kty: "OKP",
+				Kid: kid,
+				Crv: "Ed25519",
+				X: base64.RawURLEncoding.EncodeToString(
+					ti.edKey.Public().(ed25519.PublicKey)),
+			})
+		}
+	}
+
+	cnt, _ := json.Marshal(map[string]any{"keys": keys})
+	return cnt
+}
+
+func (ti *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+
+	hdr, _ := json.Marshal(map[string]any{"typ": "JWT", "alg": alg, "kid": kid})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." +
+		base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, ti.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, ti.ecKey, digest[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "EdDSA":
+		sig = ed25519.Sign(ti.edKey, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("sign(%s) failed with %s", alg, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+const (
+	testIssuerURL = "https://issuer.example.com"
+	testResource  = "https://files.example.com/mcp"
+)
+
+func testClaims(changes map[string]any) map[string]any {
+	claims := map[string]any{
+		"iss":   testIssuerURL,
+		"sub":   "alice",
+		"aud":   testResource,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "files:read",
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestJWTVerifier(t *testing.T) {
+	ti := newTestIssuer(t)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	mustWriteFile(t, jwksFile, ti.jwks("rsa", "ec", "ed"))
+
+	jv, err := newJWTVerifier(jwksFile, testIssuerURL, testResource)
+	if err != nil {
+		t.Fatalf("newJWTVerifier(%s) failed with %s", jwksFile, err)
+	}
+
+	other := newTestIssuer(t)
+	past := time.Now().Add(-time.Hour).Unix()
+	future := time.Now().Add(time.Hour).Unix()
+
+	cases := []struct {
+		token    string
+		identity string
+		scopes   []string
+		fail     bool
+	}{
+		{token: ti.sign(t, "RS256", "rsa", testClaims(nil)), identity: "alice",
+			scopes: []string{"files:read"}},
+		{token: ti.sign(t, "ES256", "ec", testClaims(map[string]any{
+			"scope": "files:read files:write",
+		})), identity: "alice", scopes: []string{"files:read", "files:write"}},
+		{token: ti.sign(t, "EdDSA", "ed", testClaims(map[string]any{
+			"sub":       nil,
+			"client_id": "agent",
+			"scope":     nil,
+			"scp":       []string{"files:write"},
+			"aud":       []string{"https://other.example.com", testResource},
+		})), identity: "agent", scopes: []string{"files:write"}},
+		{token: ti.sign(t, "ES256", "", testClaims(map[string]any{"scope": nil})),
+			identity: "alice", scopes: []string{}},
+		{token: ti.sign(t, "RS256", "rsa", testClaims(map[string]any{"exp": past})),
+			fail: true},
+		{token: ti.sign(t, "RS256", "rsa", testClaims(map[string]any{"exp": nil})),
+			fail: true},
+		{token: ti.sign(t, "RS256", "rsa", testClaims(map[string]any{"nbf": future})),
+			fail: true},
+		{token: ti.sign(t, "RS256", "rsa",
+			testClaims(map[string]any{"aud": "https://other.example.com"})), fail: true},
+		{token: ti.sign(t, "RS256", "rsa", testClaims(map[string]any{"aud": nil})),
+			fail: true},
+		{token: ti.sign(t, "RS256", "rsa",
+			testClaims(map[string]any{"iss": "https://other.example.com"})), fail: true},
+		{token: other.sign(t, "RS256", "rsa", testClaims(nil)), fail: true},
+		{token: ti.sign(t, "ES256", "rsa", testClaims(nil)), fail: true},
+		{token: ti.sign(t, "RS256", "missing", testClaims(nil)), fail: true},
+		{token: strings.TrimSuffix(ti.sign(t, "none", "", testClaims(nil)), "."),
+			fail: true},
+		{token: "not.a.token", fail: true},
+		{token: "garbage", fail: true},
+	}
+
+	for i, c := range cases {
+		info, err := jv.verify(context.Background(), c.token)
+		if c.fail {
+			if err == nil {
+				t.Errorf("verify(%d) did not fail", i)
+			}
+		} else if err != nil {
+			t.Errorf("verify(%d) failed with %s", i, err)
+		} else if info.UserID != c.identity || strings.Join(info.Scopes, " ") !=
+			strings.Join(c.scopes, " ") || info.Scopes == nil {
+
+			t.Errorf("verify(%d) got %s %v, want %s %v", i, info.UserID, info.Scopes,
+				c.identity, c.scopes)
+		}
+	}
+}
+
+func TestJWKSURL(t *testing.T) {
+	ti := newTestIssuer(t)
+	jwks := ti.jwks("rsa")
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		w.Write(jwks)
+	}))
+	defer jwksServer.Close()
+
+	jv, err := newJWTVerifier(jwksServer.URL, "", testResource)
+	if err != nil {
+		t.Fatalf("newJWTVerifier(%s) failed with %s", jwksServer.URL, err)
+	}
+
+	ctx := context.Background()
+	_, err = jv.verify(ctx, ti.sign(t, "RS256", "rsa", testClaims(nil)))
+	if err != nil {
+		t.Errorf("verify(rsa) failed with %s", err)
+	}
+
+	// The issuer rotates its keys; they are not fetched again until jwksRefresh has passed.
+	jwks = ti.jwks("ec")
+	token := ti.sign(t, "ES256", "ec", testClaims(nil))
+	_, err = jv.verify(ctx, token)
+	if err == nil {
+		t.Errorf("verify(ec) did not fail")
+	}
+
+	jv.mu.Lock()
+	jv.fetched = time.Now().Add(-2 * jwksRefresh)
+	jv.mu.Unlock()
+	_, err = jv.verify(ctx, token)
+	if err != nil {
+		t.Errorf("verify(ec) failed with %s", err)
+	}
+
+	_, err = newJWTVerifier(jwksServer.URL+"/missing", "", "")
+	if err == nil {
+		t.Errorf("newJWTVerifier() did not fail without a resource")
+	}
+	_, err = newJWTVerifier(filepath.Join(t.TempDir(), "missing.json"), "", testResource)
+	if err == nil {
+		t.Errorf("newJWTVerifier(missing.json) did not fail")
+	}
+}
+
+func TestRequireJWT(t *testing.T) {
+	ti := newTestIssuer(t)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	mustWriteFile(t, jwksFile, ti.jwks("ec"))
+
+	jv, err := newJWTVerifier(jwksFile, testIssuerURL, testResource)
+	if err != nil {
+		t.Fatalf("newJWTVerifier(%s) failed with %s", jwksFile, err)
+	}
+
+	var identity string
+	var scopes []string
+	handler := jv.requireJWT()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = requestIdentity(r)
+		scopes = requestScopes(r)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, testResource, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("ServeHTTP() got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	want := "Bearer resource_metadata=https://files.example.com" + protectedResourcePath + "/mcp"
+	if hdr := w.Header().Get("WWW-Authenticate"); hdr != want {
+		t.Errorf("WWW-Authenticate got %s, want %s", hdr, want)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, testResource, nil)
+	r.Header.Set("Authorization", "Bearer "+ti.sign(t, "ES256", "ec", testClaims(nil)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() got %d, want %d", w.Code, http.StatusOK)
+	} else if identity != "alice" || len(scopes) != 1 || scopes[0] != scopeRead {
+		t.Errorf("ServeHTTP() got %s %v, want alice [%s]", identity, scopes, scopeRead)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "https://files.example.com"+protectedResourcePath,
+		nil)
+	w = httptest.NewRecorder()
+	jv.metadataHandler().ServeHTTP(w, r)
+	var prm oauthex.ProtectedResourceMetadata
+	err = json.Unmarshal(w.Body.Bytes(), &prm)
+	if err != nil {
+		t.Errorf("Unmarshal(metadata) failed with %s", err)
+	} else if prm.Resource != testResource || len(prm.AuthorizationServers) != 1 ||
+		prm.AuthorizationServers[0] != testIssuerURL || len(prm.ScopesSupported) != 2 {
+
+		t.Errorf("metadata got %+v", prm)
+	}
+}
+
+func TestScopeTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("file"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+	}
+
+	cases := []struct {
+		scopes []string
+		tools  int
+	}{
+		{scopes: nil, tools: len(toolScopes)},
+		{scopes: []string{scopeRead}, tools: len(toolScopes)},
+		{scopes: []string{scopeWrite}, tools: 0},
+		{scopes: []string{}, tools: 0},
+	}
+
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	for _, c := range cases {
+		_, cs := connectClient(t, sf.newServer(sessionInfo{scopes: c.scopes}), opts)
+		res, err := cs.ListTools(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("ListTools() failed with %s", err)
+		} else if len(res.Tools) != c.tools {
+			t.Errorf("ListTools(%v) got %d tools, want %d", c.scopes, len(res.Tools), c.tools)
+		}
+	}
+
+	if !allowScope([]string{scopeWrite}, "write_file") {
+		t.Errorf("allowScope(files:write, write_file) got false")
+	}
+	if allowScope([]string{scopeRead}, "write_file") {
+		t.Errorf("allowScope(files:read, write_file) got true")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var errPathEscape = errors.New("outside of the served directories")
+
+func errEscapes(p string) error {
+	return fmt.Errorf("%s: %w", p, errPathEscape)
+}
+
+// resolvePath returns p, which is relative to the current directory, as a path relative to
+// the top of the tree. p may also be an absolute path, or start with ~ for the home
+// directory, if it is within one of the server roots. Paths are cleaned, so ./ and trailing
+// slashes are removed; spaces are kept, since they may be part of names.
+func (ft fileTools) resolvePath(p string) (string, error) {
+	requested := p
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.ToSlash(homeDir) + p[1:]
+	}
+
+	if path.IsAbs(p) {
+		rel, ok := ft.rootRelative(path.Clean(p))
+		if !ok {
+			return "", errEscapes(requested)
+		}
+		return rel, nil
+	}
+
+	p = ft.joinCwd(p)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", errEscapes(requested)
+	}
+	return p, nil
+}
+
+// rootRelative returns the absolute path p relative to the top of the tree, if it is within
+// one of the server roots.
+func (ft fileTools) rootRelative(p string) (string, bool) {
+	dir := filepath.FromSlash(p)
+	for _, sr := range ft.serverRoots {
+		rel, ok := relativePath(sr.dir, dir)
+		if !ok {
+			// The path might be through the real directory of the root, if the root is
+			// through a symbolic link.
+			realDir, err := filepath.EvalSymlinks(sr.dir)
+			if err != nil || realDir == sr.dir {
+				continue
+			}
+			rel, ok = relativePath(realDir, dir)
+			if !ok {
+				continue
+			}
+		}
+		return path.Join(sr.name, filepath.ToSlash(rel)), true
+	}
+	return "", false
+}
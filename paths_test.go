@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestResolvePath(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	srcDir := filepath.Join(homeDir, "src")
+	docsDir := filepath.Join(t.TempDir(), "docs")
+	linkDir := filepath.Join(t.TempDir(), "link")
+	err := os.MkdirAll(docsDir, 0755)
+	if err != nil {
+		t.Fatalf("MkdirAll(%s) failed with %s", docsDir, err)
+	}
+	err = os.Symlink(docsDir, linkDir)
+	if err != nil {
+		t.Fatalf("Symlink(%s) failed with %s", linkDir, err)
+	}
+
+	cases := []struct {
+		roots []serverRoot
+		path  string
+		want  string
+		fail  bool
+	}{
+		{roots: []serverRoot{{dir: srcDir}}, path: "", want: "."},
+		{roots: []serverRoot{{dir: srcDir}}, path: ".", want: "."},
+		{roots: []serverRoot{{dir: srcDir}}, path: "x.go", want: "x.go"},
+		{roots: []serverRoot{{dir: srcDir}}, path: "./x.go", want: "x.go"},
+		{roots: []serverRoot{{dir: srcDir}}, path: "pkg/", want: "pkg"},
+		{roots: []serverRoot{{dir: srcDir}}, path: "pkg/./x.go", want: "pkg/x.go"},
+		{roots: []serverRoot{{dir: srcDir}}, path: " notes.txt ", want: " notes.txt "},
+		{roots: []serverRoot{{dir: srcDir}}, path: "pkg /x.go/", want: "pkg /x.go"},
+		{roots: []serverRoot{{dir: srcDir}}, path: "pkg/../x.go", want: "x.go"},
+		{roots: []serverRoot{{dir: srcDir}}, path: srcDir, want: "."},
+		{roots: []serverRoot{{dir: srcDir}}, path: srcDir + "/", want: "."},
+		{roots: []serverRoot{{dir: srcDir}}, path: srcDir + "/pkg/x.go", want: "pkg/x.go"},
+		{roots: []serverRoot{{dir: srcDir}}, path: "~/src/pkg/x.go", want: "pkg/x.go"},
+		{roots: []serverRoot{{dir: srcDir}}, path: "~/src", want: "."},
+		{roots: []serverRoot{{dir: srcDir}}, path: "~", fail: true},
+		{roots: []serverRoot{{dir: srcDir}}, path: "~/.ssh/id_rsa", fail: true},
+		{roots: []serverRoot{{dir: srcDir}}, path: "/etc/passwd", fail: true},
+		{roots: []serverRoot{{dir: srcDir}}, path: srcDir + "2/x.go", fail: true},
+		{roots: []serverRoot{{dir: srcDir}}, path: "..", fail: true},
+		{roots: []serverRoot{{dir: srcDir}}, path: "../x.go", fail: true},
+		{roots: []serverRoot{{dir: srcDir}}, path: "pkg/../../x.go", fail: true},
+		{roots: []serverRoot{{dir: linkDir}}, path: docsDir + "/a.md", want: "a.md"},
+		{roots: []serverRoot{{dir: linkDir}}, path: linkDir + "/a.md", want: "a.md"},
+		{
+			roots: []serverRoot{{name: "src", dir: srcDir}, {name: "docs", dir: docsDir}},
+			path:  docsDir + "/a.md",
+			want:  "docs/a.md",
+		},
+		{
+			roots: []serverRoot{{name: "src", dir: srcDir}, {name: "docs", dir: docsDir}},
+			path:  "~/src/",
+			want:  "src",
+		},
+		{
+			roots: []serverRoot{{name: "src", dir: srcDir}, {name: "docs", dir: docsDir}},
+			path:  "src/x.go",
+			want:  "src/x.go",
+		},
+	}
+
+	for _, c := range cases {
+		ft := fileTools{serverRoots: c.roots}
+		got, err := ft.resolvePath(c.path)
+		if c.fail {
+			if err == nil {
+				t.Errorf("resolvePath(%s) got %s, want error", c.path, got)
+			} else if !errors.Is(err, errPathEscape) {
+				t.Errorf("resolvePath(%s) failed with %s", c.path, err)
+			}
+		} else if err != nil {
+			t.Errorf("resolvePath(%s) failed with %s", c.path, err)
+		} else if got != c.want {
+			t.Errorf("resolvePath(%s) got %s, want %s", c.path, got, c.want)
+		}
+	}
+}
+
+func TestResolvePathTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "pkg", "x.go"), []byte("package pkg"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+	}
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+	cases := []struct {
+		tool string
+		path string
+		want string
+	}{
+		{tool: "read_file", path: filepath.Join(tempDir, "pkg", "x.go"), want: "pkg/x.go"},
+		{tool: "read_file", path: "./pkg/x.go", want: "pkg/x.go"},
+		{tool: "get_file_info", path: tempDir + "/pkg/", want: "pkg"},
+		{tool: "list_directory", path: "pkg/", want: "pkg"},
+		{tool: "list_directory", path: tempDir, want: "."},
+	}
+
+	for _, c := range cases {
+		res := callTool(t, cs, c.tool, map[string]any{"path": c.path})
+		if res.IsError {
+			t.Errorf("%s(%s) failed with %v", c.tool, c.path, res.Content)
+		} else if out := res.StructuredContent.(map[string]any); out["path"] != c.want {
+			t.Errorf("%s(%s) got path %v, want %s", c.tool, c.path, out["path"], c.want)
+		}
+	}
+
+	res := callTool(t, cs, "read_file", map[string]any{"path": "/etc/passwd"})
+	if !res.IsError {
+		t.Errorf("read_file(/etc/passwd) did not fail")
+	} else if out := res.StructuredContent.(map[string]any); out["code"] != "path_escape" ||
+		!strings.Contains(out["message"].(string), "outside of the served directories") {
+
+		t.Errorf("read_file(/etc/passwd) got %v, want path_escape", out)
+	}
+}
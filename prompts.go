@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// explainFilePrompt is the name of the prompt which asks the model to explain a file; the
+// client may complete its path argument.
+const explainFilePrompt = "explain_file"
+
+func (ft fileTools) registerPrompts(srvr *mcp.Server) {
+	srvr.AddPrompt(&mcp.Prompt{
+		Name:        explainFilePrompt,
+		Description: "Explain what a file is for and how it works.",
+		Arguments: []*mcp.PromptArgument{
+			{
+				Name:        "path",
+				Description: "path to the file relative to the current directory",
+				Required:    true,
+			},
+		},
+	}, ft.handleExplainFile)
+}
+
+func (ft fileTools) handleExplainFile(ctx context.Context,
+	req *mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+
+	slog.Info("get prompt", "name", req.Params.Name, "arguments", req.Params.Arguments)
+
+	arg := req.Params.Arguments["path"]
+	if arg == "" {
+		return nil, fmt.Errorf("%s: path is required", explainFilePrompt)
+	}
+	p, err := ft.resolvePath(arg)
+	if err != nil {
+		return nil, err
+	}
+	err = ft.checkAllowed(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.GetPromptResult{
+		Description: fmt.Sprintf("Explain %s", p),
+		Messages: []*mcp.PromptMessage{
+			{
+				Role: "user",
+				Content: &mcp.TextContent{
+					Text: fmt.Sprintf("Read the file %s with the read_file tool, and explain "+
+						"what it is for and how it works.", arg),
+				},
+			},
+		},
+	}, nil
+}
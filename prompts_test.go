@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestExplainFilePrompt(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "src", "main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "src", "manual.txt"), []byte("manual"))
+	mustWriteFile(t, filepath.Join(tempDir, ".env"), []byte("SECRET=1"))
+
+	deny, err := parseDenyList(nil, true)
+	if err != nil {
+		t.Fatalf("parseDenyList() failed with %s", err)
+	}
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		deny:        deny,
+	}
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+	ctx := context.Background()
+
+	res, err := cs.ListPrompts(ctx, nil)
+	if err != nil {
+		t.Fatalf("ListPrompts() failed with %s", err)
+	} else if len(res.Prompts) != 1 || res.Prompts[0].Name != explainFilePrompt {
+		t.Errorf("ListPrompts() got %v, want %s", res.Prompts, explainFilePrompt)
+	}
+
+	cmpl, err := cs.Complete(ctx, &mcp.CompleteParams{
+		Ref:      &mcp.CompleteReference{Type: "ref/prompt", Name: explainFilePrompt},
+		Argument: mcp.CompleteParamsArgument{Name: "path", Value: "src/ma"},
+	})
+	if err != nil {
+		t.Fatalf("Complete(src/ma) failed with %s", err)
+	} else if strings.Join(cmpl.Completion.Values, ",") != "src/main.go,src/manual.txt" {
+		t.Errorf("Complete(src/ma) got %v", cmpl.Completion.Values)
+	}
+
+	gpr, err := cs.GetPrompt(ctx, &mcp.GetPromptParams{
+		Name:      explainFilePrompt,
+		Arguments: map[string]string{"path": "src/main.go"},
+	})
+	if err != nil {
+		t.Fatalf("GetPrompt(src/main.go) failed with %s", err)
+	} else if len(gpr.Messages) != 1 {
+		t.Errorf("GetPrompt(src/main.go) got %d messages, want 1", len(gpr.Messages))
+	} else if tc, ok := gpr.Messages[0].Content.(*mcp.TextContent); !ok ||
+		!strings.Contains(tc.Text, "src/main.go") {
+
+		t.Errorf("GetPrompt(src/main.go) got %v", gpr.Messages[0].Content)
+	}
+
+	for _, p := range []string{"", ".env", "../outside.txt"} {
+		_, err := cs.GetPrompt(ctx, &mcp.GetPromptParams{
+			Name:      explainFilePrompt,
+			Arguments: map[string]string{"path": p},
+		})
+		if err == nil {
+			t.Errorf("GetPrompt(%s) did not fail", p)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// protoLog writes the protocol messages of sessions, as JSON lines, to a single file, to a
+// file per session in a directory, or to both.
+type protoLog struct {
+	mu  sync.Mutex
+	w   io.Writer
+	dir string
+	seq int
+}
+
+type protoRecord struct {
+	Time    string `json:"time"`
+	Session string `json:"session"`
+	Remote  string `json:"remote,omitempty"`
+	Dir     string `json:"dir"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+	Result  any    `json:"result,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// sessionProtoLog writes the protocol messages of a single session.
+type sessionProtoLog struct {
+	pl         *protoLog
+	remoteAddr string
+
+	mu   sync.Mutex
+	file *os.File
+	err  error
+}
+
+func (pl *protoLog) session(remoteAddr string) *sessionProtoLog {
+	return &sessionProtoLog{
+		pl:         pl,
+		remoteAddr: remoteAddr,
+	}
+}
+
+// addMiddleware adds middleware to srvr to log the messages it receives and sends.
+func (spl *sessionProtoLog) addMiddleware(srvr *mcp.Server) {
+	srvr.AddReceivingMiddleware(spl.middleware("read", "write"))
+	srvr.AddSendingMiddleware(spl.middleware("write", "read"))
+}
+
+func (spl *sessionProtoLog) middleware(request, response string) mcp.Middleware {
+	return func(next mcp.MethodHandler) mcp.MethodHandler {
+		return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+			ss, _ := req.GetSession().(*mcp.ServerSession)
+			spl.log(ss, protoRecord{
+				Dir:    request,
+				Method: method,
+				Params: req.GetParams(),
+			})
+
+			res, err := next(ctx, method, req)
+
+			if res != nil || err != nil {
+				rec := protoRecord{
+					Dir:    response,
+					Method: method,
+					Result: res,
+				}
+				if err != nil {
+					rec.Error = err.Error()
+				}
+				spl.log(ss, rec)
+			}
+			return res, err
+		}
+	}
+}
+
+func (spl *sessionProtoLog) log(ss *mcp.ServerSession, rec protoRecord) {
+	rec.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if ss != nil {
+		rec.Session = ss.ID()
+	}
+	rec.Remote = spl.remoteAddr
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		line, _ = json.Marshal(protoRecord{
+			Time:    rec.Time,
+			Session: rec.Session,
+			Remote:  rec.Remote,
+			Dir:     rec.Dir,
+			Method:  rec.Method,
+			Error:   fmt.Sprintf("marshal: %s", err),
+		})
+	}
+	line = append(line, '\n')
+
+	if spl.pl.w != nil {
+		spl.pl.mu.Lock()
+		spl.pl.w.Write(line)
+		spl.pl.mu.Unlock()
+	}
+
+	if spl.pl.dir != "" {
+		spl.mu.Lock()
+		defer spl.mu.Unlock()
+
+		if spl.file == nil && spl.err == nil {
+			spl.file, spl.err = spl.pl.openSession(rec.Session)
+			if spl.err != nil {
+				slog.Error("open protocol log", "session", rec.Session, "error", spl.err)
+			} else if ss != nil {
+				go func() {
+					ss.Wait()
+
+					spl.mu.Lock()
+					spl.file.Close()
+					spl.err = os.ErrClosed
+					spl.mu.Unlock()
+				}()
+			}
+		}
+		if spl.err == nil {
+			spl.file.Write(line)
+		}
+	}
+}
+
+// openSession opens a protocol log file in the directory for the session.
+func (pl *protoLog) openSession(sessionID string) (*os.File, error) {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+			r == '-' || r == '_' {
+
+			return r
+		}
+		return -1
+	}, sessionID)
+	if name == "" {
+		pl.mu.Lock()
+		pl.seq++
+		name = fmt.Sprintf("session-%d-%d", os.Getpid(), pl.seq)
+		pl.mu.Unlock()
+	}
+
+	return os.OpenFile(filepath.Join(pl.dir, name+".log"),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+}
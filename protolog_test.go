@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func readProtoRecords(t *testing.T, cnt []byte) []protoRecord {
+	t.Helper()
+
+	var recs []protoRecord
+	scanner := bufio.NewScanner(bytes.NewReader(cnt))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var rec protoRecord
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			t.Fatalf("Unmarshal(%s) failed with %s", scanner.Bytes(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestProtoLog(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("content"))
+
+	var buf bytes.Buffer
+	logDir := t.TempDir()
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		protoLog:    &protoLog{w: &buf, dir: logDir},
+	}
+
+	httpServer := httptest.NewServer(mcp.NewStreamableHTTPHandler(sf.getServer("http"), nil))
+	defer httpServer.Close()
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "0.1.0"},
+		&mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}})
+	cs, err := client.Connect(context.Background(),
+		&mcp.StreamableClientTransport{Endpoint: httpServer.URL}, nil)
+	if err != nil {
+		t.Fatalf("Connect() failed with %s", err)
+	}
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "file.txt"}); res.IsError {
+		t.Errorf("read_file(file.txt) failed with %v", res.Content)
+	}
+	sessionID := cs.ID()
+	cs.Close()
+
+	sf.protoLog.mu.Lock()
+	recs := readProtoRecords(t, buf.Bytes())
+	sf.protoLog.mu.Unlock()
+
+	var read, write bool
+	for _, rec := range recs {
+		if rec.Session != sessionID || rec.Remote == "" || rec.Time == "" {
+			t.Errorf("got record %v, want session %s and remote", rec, sessionID)
+		}
+		if rec.Method == "tools/call" {
+			if rec.Dir == "read" && rec.Params != nil {
+				read = true
+			} else if rec.Dir == "write" && rec.Result != nil {
+				write = true
+			}
+		}
+	}
+	if !read || !write {
+		t.Errorf("got records %v, want tools/call read and write", recs)
+	}
+
+	cnt, err := os.ReadFile(filepath.Join(logDir, sessionID+".log"))
+	if err != nil {
+		t.Fatalf("ReadFile(%s.log) failed with %s", sessionID, err)
+	}
+	if sessionRecs := readProtoRecords(t, cnt); len(sessionRecs) < len(recs) {
+		t.Errorf("got %d session records, want at least %d", len(sessionRecs), len(recs))
+	}
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(filepath.Join(logDir, sessionID+".log"))
+		if err != nil {
+			t.Fatalf("Stat(%s.log) failed with %s", sessionID, err)
+		} else if fi.Mode().Perm() != 0600 {
+			t.Errorf("Stat(%s.log) got mode %s, want 0600", sessionID, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestStdioProtoLog(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("content"))
+
+	var buf bytes.Buffer
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		protoLog:    &protoLog{w: &buf},
+	}
+
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.stdioServer(), opts)
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "file.txt"}); res.IsError {
+		t.Errorf("read_file(file.txt) failed with %v", res.Content)
+	}
+
+	sf.protoLog.mu.Lock()
+	recs := readProtoRecords(t, buf.Bytes())
+	sf.protoLog.mu.Unlock()
+
+	var read, write bool
+	for _, rec := range recs {
+		if rec.Method == "tools/call" {
+			if rec.Dir == "read" && rec.Params != nil {
+				read = true
+			} else if rec.Dir == "write" && rec.Result != nil {
+				write = true
+			}
+		}
+	}
+	if !read || !write {
+		t.Errorf("got records %v, want tools/call read and write", recs)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// redactPattern finds secrets. If the pattern has a group, only the first group is the
+// secret, otherwise the whole match is. If check is not nil, it must also return true.
+type redactPattern struct {
+	name  string
+	re    *regexp.Regexp
+	check func(s string) bool
+}
+
+// defaultRedact is the built-in detectors, in the order they are applied.
+var defaultRedact = []redactPattern{
+	{
+		name: "private-key",
+		re: regexp.MustCompile(
+			`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`),
+	},
+	{
+		name: "jwt",
+		re:   regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`),
+	},
+	{
+		name: "aws-access-key",
+		re:   regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`),
+	},
+	{
+		name: "aws-secret-key",
+		re: regexp.MustCompile(
+			`(?i)aws_?secret_?(?:access_?)?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`),
+	},
+	{
+		name: "env-secret",
+		re: regexp.MustCompile(
+			`(?m)^[ \t]*(?:export[ \t]+)?[A-Z0-9_]*(?:SECRET|PASSWORD|PASSWD|TOKEN|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*[ \t]*=[ \t]*["']?([^\s"'#]+)`),
+	},
+	{
+		name: "config-secret",
+		re: regexp.MustCompile(
+			`(?m)^[ \t]*["']?[a-z0-9_.-]*(?:password|passwd|secret|token|api_?key|access_?key|private_?key)(?:_[a-z0-9_]*)?["']?[ \t]*[:=][ \t]*["']?([^\s"'#(),;]+)["']?,?[ \t]*(?:#.*)?$`),
+	},
+	{
+		name:  "high-entropy",
+		re:    regexp.MustCompile(`[A-Za-z0-9+/_=-]{24,}`),
+		check: highEntropy,
+	},
+}
+
+// highEntropy returns true if s looks like a random key or token: it mixes upper and lower
+// case letters and digits, and has more entropy than a hex string can have.
+func highEntropy(s string) bool {
+	var upper, lower, digits bool
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+		if unicode.IsUpper(r) {
+			upper = true
+		} else if unicode.IsLower(r) {
+			lower = true
+		} else if unicode.IsDigit(r) {
+			digits = true
+		}
+	}
+	if !upper || !lower || !digits {
+		return false
+	}
+
+	var entropy float64
+	for _, n := range counts {
+		p := float64(n) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy > 4.0
+}
+
+// redactor replaces secrets in file contents before they are returned to the client.
+type redactor struct {
+	patterns []redactPattern
+}
+
+// newRedactor returns a redactor which uses the built-in detectors, if useDefault, the high
+// entropy detector, if useEntropy, and the user-defined regular expressions in exprs. The high
+// entropy detector is not on by default, because it also finds identifiers and hashes, such
+// as those in go.sum.
+func newRedactor(exprs []string, useDefault, useEntropy bool) (*redactor, error) {
+	rd := &redactor{}
+	var entropy []redactPattern
+	for _, rp := range defaultRedact {
+		if rp.check != nil {
+			if useEntropy {
+				entropy = append(entropy, rp)
+			}
+		} else if useDefault {
+			rd.patterns = append(rd.patterns, rp)
+		}
+	}
+
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redact: %s", err)
+		}
+		rd.patterns = append(rd.patterns, redactPattern{
+			name: "custom",
+			re:   re,
+		})
+	}
+
+	// The high entropy detector is applied last, so that secrets found by the other detectors
+	// are reported by name.
+	rd.patterns = append(rd.patterns, entropy...)
+	if len(rd.patterns) == 0 {
+		return nil, nil
+	}
+	return rd, nil
+}
+
+// redaction is a secret found in a string: s[start:end] is replaced by [REDACTED:name].
+type redaction struct {
+	start, end int
+	name       string
+}
+
+// find returns the secrets in s, in order. Where the secrets found by patterns overlap, the
+// one found by the earlier pattern is used. A nil redactor doesn't find anything.
+func (rd *redactor) find(s string) []redaction {
+	if rd == nil {
+		return nil
+	}
+
+	var found []redaction
+	for _, rp := range rd.patterns {
+		for _, m := range rp.re.FindAllStringSubmatchIndex(s, -1) {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			if start == end || (rp.check != nil && !rp.check(s[start:end])) ||
+				slices.ContainsFunc(found, func(r redaction) bool {
+					return start < r.end && r.start < end
+				}) {
+
+				continue
+			}
+			found = append(found, redaction{start: start, end: end, name: rp.name})
+		}
+	}
+
+	slices.SortFunc(found, func(a, b redaction) int {
+		return a.start - b.start
+	})
+	return found
+}
+
+// replace returns s with each of found, which are in order and within s, replaced by
+// [REDACTED:name].
+func replace(s string, found []redaction) string {
+	var b strings.Builder
+	var last int
+	for _, r := range found {
+		b.WriteString(s[last:r.start])
+		b.WriteString("[REDACTED:" + r.name + "]")
+		last = r.end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// redact returns s with each secret replaced by [REDACTED:name], and the number of secrets
+// which were replaced. A nil redactor doesn't replace anything.
+func (rd *redactor) redact(s string) (string, int) {
+	found := rd.find(s)
+	if len(found) == 0 {
+		return s, 0
+	}
+	return replace(s, found), len(found)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// clientRoots is the part of the tree, below the server roots, which a client has declared
+// as its roots. Paths are relative to the top of the tree; "." is the whole tree.
+// A nil clientRoots allows access to the whole tree.
+type clientRoots struct {
+	ready chan struct{}
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func newClientRoots() *clientRoots {
+	return &clientRoots{
+		ready: make(chan struct{}),
+	}
+}
+
+// wait waits until the client roots have been listed.
+func (cr *clientRoots) wait(ctx context.Context) error {
+	if cr == nil {
+		return nil
+	}
+
+	select {
+	case <-cr.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (cr *clientRoots) setPaths(paths []string) {
+	cr.mu.Lock()
+	cr.paths = paths
+	cr.mu.Unlock()
+}
+
+// contains returns true if path is within one of the client roots.
+func (cr *clientRoots) contains(path string) bool {
+	if cr == nil {
+		return true
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return pathsContain(cr.paths, path)
+}
+
+// visible returns true if path is within one of the client roots, or if it is a directory
+// on the way to one of the client roots.
+func (cr *clientRoots) visible(path string) bool {
+	if cr == nil {
+		return true
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return pathsVisible(cr.paths, path)
+}
+
+// pathsContain returns true if path is within one of paths, which are relative to the top
+// of the tree; "." is the whole tree.
+func pathsContain(paths []string, path string) bool {
+	if path == "" {
+		path = "."
+	}
+
+	for _, p := range paths {
+		if p == "." || path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pathsVisible returns true if path is within one of paths, or if it is a directory on the
+// way to one of paths.
+func pathsVisible(paths []string, path string) bool {
+	if path == "" || path == "." || pathsContain(paths, path) {
+		return true
+	}
+
+	for _, p := range paths {
+		if strings.HasPrefix(p, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+var errOutsideOfRoots = errors.New("outside of client roots")
+
+func errOutsideRoots(path string) error {
+	return fmt.Errorf("%s: %w", path, errOutsideOfRoots)
+}
+
+func (ft fileTools) handleInitialized(ctx context.Context, req *mcp.InitializedRequest) {
+	// Listing the roots requires a round trip to the client, which can't happen while
+	// handling a notification from the client.
+	ss := req.Session
+	go func() {
+		ft.listClientRoots(context.WithoutCancel(ctx), ss)
+		close(ft.roots.ready)
+	}()
+}
+
+func (ft fileTools) handleRootsListChanged(ctx context.Context,
+	req *mcp.RootsListChangedRequest) {
+
+	ss := req.Session
+	go func() {
+		<-ft.roots.ready
+		ft.listClientRoots(context.WithoutCancel(ctx), ss)
+	}()
+}
+
+// listClientRoots asks the client for its roots and sets the client roots to their
+// intersection with the server roots. If the client does not support roots, the whole tree
+// is available. If listing the roots fails, nothing is available.
+func (ft fileTools) listClientRoots(ctx context.Context, ss *mcp.ServerSession) {
+	params := ss.InitializeParams()
+	if params == nil || params.Capabilities == nil || params.Capabilities.RootsV2 == nil {
+		slog.Info("client roots not supported", "session", ss.ID())
+		ft.roots.setPaths([]string{"."})
+		return
+	}
+
+	res, err := ss.ListRoots(ctx, nil)
+	if err != nil {
+		slog.Error("list roots", "session", ss.ID(), "error", err)
+		ft.roots.setPaths(nil)
+		return
+	}
+
+	var paths []string
+	for _, r := range res.Roots {
+		paths = append(paths, rootPaths(ft.serverRoots, r.URI)...)
+	}
+
+	slog.Info("client roots", "session", ss.ID(), "roots", res.Roots, "paths", paths)
+	ft.roots.setPaths(paths)
+}
+
+// rootPaths returns the parts of the server roots which are also under the client root
+// named by uri, as paths relative to the top of the tree.
+func rootPaths(serverRoots []serverRoot, uri string) []string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return nil
+	}
+	dir := filepath.Clean(filepath.FromSlash(u.Path))
+
+	var paths []string
+	for _, sr := range serverRoots {
+		if rel, ok := relativePath(sr.dir, dir); ok {
+			paths = append(paths, path.Join(sr.name, filepath.ToSlash(rel)))
+		} else if _, ok := relativePath(dir, sr.dir); ok {
+			paths = append(paths, path.Join(sr.name, "."))
+		}
+	}
+	return paths
+}
+
+// relativePath returns the path of target relative to base, if target is within base.
+func relativePath(base, target string) (string, bool) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestRootPaths(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file URIs differ on windows")
+	}
+
+	single := []serverRoot{{dir: "/home/me"}}
+	multiple := []serverRoot{
+		{name: "src", dir: "/home/me/src"},
+		{name: "docs", dir: "/home/me/docs"},
+		{name: "log", dir: "/var/log"},
+	}
+
+	cases := []struct {
+		roots []serverRoot
+		uri   string
+		paths []string
+	}{
+		{roots: single, uri: "file:///home/me/src", paths: []string{"src"}},
+		{roots: single, uri: "file:///home/me/src/proj/", paths: []string{"src/proj"}},
+		{roots: single, uri: "file:///home/me", paths: []string{"."}},
+		{roots: single, uri: "file:///home", paths: []string{"."}},
+		{roots: single, uri: "file:///", paths: []string{"."}},
+		{roots: single, uri: "file:///home/you"},
+		{roots: single, uri: "file:///home/meme"},
+		{roots: single, uri: "file:///home/me/../you"},
+		{roots: single, uri: "https://example.com/home/me"},
+		{roots: single, uri: "file:"},
+		{roots: multiple, uri: "file:///home/me/src/proj", paths: []string{"src/proj"}},
+		{roots: multiple, uri: "file:///home/me/docs", paths: []string{"docs"}},
+		{roots: multiple, uri: "file:///home/me", paths: []string{"src", "docs"}},
+		{roots: multiple, uri: "file:///", paths: []string{"src", "docs", "log"}},
+		{roots: multiple, uri: "file:///home/me/tmp"},
+	}
+
+	for _, c := range cases {
+		paths := rootPaths(c.roots, c.uri)
+		if !reflect.DeepEqual(paths, c.paths) {
+			t.Errorf("rootPaths(%v, %s) got %v want %v", c.roots, c.uri, paths, c.paths)
+		}
+	}
+}
+
+func TestClientRoots(t *testing.T) {
+	cr := &clientRoots{paths: []string{"src/proj", "docs"}}
+
+	cases := []struct {
+		path     string
+		contains bool
+		visible  bool
+	}{
+		{path: "", visible: true},
+		{path: ".", visible: true},
+		{path: "src", visible: true},
+		{path: "src/proj", contains: true, visible: true},
+		{path: "src/proj/main.go", contains: true, visible: true},
+		{path: "src/projects", contains: false, visible: false},
+		{path: "src/other", contains: false, visible: false},
+		{path: "docs", contains: true, visible: true},
+		{path: "docs/readme.md", contains: true, visible: true},
+		{path: "doc", contains: false, visible: false},
+		{path: "file.txt", contains: false, visible: false},
+	}
+
+	for _, c := range cases {
+		if cr.contains(c.path) != c.contains {
+			t.Errorf("contains(%s) got %v want %v", c.path, !c.contains, c.contains)
+		}
+		if cr.visible(c.path) != c.visible {
+			t.Errorf("visible(%s) got %v want %v", c.path, !c.visible, c.visible)
+		}
+	}
+
+	var nilRoots *clientRoots
+	if !nilRoots.contains("any/path") || !nilRoots.visible("any/path") {
+		t.Errorf("nil clientRoots does not allow any/path")
+	}
+}
+
+func connectClient(t *testing.T, srvr *mcp.Server, opts *mcp.ClientOptions,
+	roots ...*mcp.Root) (*mcp.Client, *mcp.ClientSession) {
+
+	t.Helper()
+
+	ctx := context.Background()
+	st, ct := mcp.NewInMemoryTransports()
+	ss, err := srvr.Connect(ctx, st, nil)
+	if err != nil {
+		t.Fatalf("Connect() failed with %s", err)
+	}
+	t.Cleanup(func() { ss.Close() })
+
+	client := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "0.1.0"}, opts)
+	client.AddRoots(roots...)
+	cs, err := client.Connect(ctx, ct, nil)
+	if err != nil {
+		t.Fatalf("Connect() failed with %s", err)
+	}
+	t.Cleanup(func() { cs.Close() })
+
+	return client, cs
+}
+
+func callTool(t *testing.T, cs *mcp.ClientSession, name string,
+	args map[string]any) *mcp.CallToolResult {
+
+	t.Helper()
+
+	res, err := cs.CallTool(context.Background(), &mcp.CallToolParams{
+		Name:      name,
+		Arguments: args,
+	})
+	if err != nil {
+		t.Fatalf("CallTool(%s) failed with %s", name, err)
+	}
+	return res
+}
+
+func TestSessionRoots(t *testing.T) {
+	tempDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("EvalSymlinks() failed with %s", err)
+	}
+
+	mustWriteFile(t, filepath.Join(tempDir, "a", "file.txt"), []byte("a"))
+	mustWriteFile(t, filepath.Join(tempDir, "b", "file.txt"), []byte("b"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+	}
+	srvr := sf.newServer(sessionInfo{transport: "test"})
+
+	client, cs := connectClient(t, srvr, nil,
+		&mcp.Root{URI: "file://" + filepath.ToSlash(filepath.Join(tempDir, "a"))})
+
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "a/file.txt"}); res.IsError {
+		t.Errorf("read_file(a/file.txt) failed with %v", res.Content)
+	}
+	if res := callTool(t, cs, "read_file", map[string]any{"path": "b/file.txt"}); !res.IsError {
+		t.Errorf("read_file(b/file.txt) did not fail")
+	}
+
+	res := callTool(t, cs, "list_directory", map[string]any{"path": "."})
+	if res.IsError {
+		t.Errorf("list_directory(.) failed with %v", res.Content)
+	} else if out := res.StructuredContent.(map[string]any); out["count"] != 1.0 {
+		t.Errorf("list_directory(.) got %v, want a", out["entries"])
+	}
+
+	client.AddRoots(&mcp.Root{URI: "file://" + filepath.ToSlash(filepath.Join(tempDir, "b"))})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res := callTool(t, cs, "read_file", map[string]any{"path": "b/file.txt"})
+		if !res.IsError {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("read_file(b/file.txt) failed after roots changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srvr = sf.newServer(sessionInfo{transport: "test"})
+	_, cs = connectClient(t, srvr, &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}})
+	for _, p := range []string{"a/file.txt", "b/file.txt"} {
+		if res := callTool(t, cs, "read_file", map[string]any{"path": p}); res.IsError {
+			t.Errorf("read_file(%s) without client roots failed with %v", p, res.Content)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+type permission int
+
+const (
+	readOnly permission = iota
+	readWrite
+)
+
+func parsePermission(s string) (permission, error) {
+	switch s {
+	case "ro":
+		return readOnly, nil
+	case "rw":
+		return readWrite, nil
+	}
+	return 0, fmt.Errorf("unknown permission: %s: expected ro or rw", s)
+}
+
+func (perm permission) String() string {
+	switch perm {
+	case readOnly:
+		return "read-only"
+	case readWrite:
+		return "read-write"
+	}
+	return fmt.Sprintf("permission(%d)", int(perm))
+}
+
+// serverRoot is a directory served by filemcp. When there is more than one, each root is
+// named and paths start with the name of the root.
+type serverRoot struct {
+	name string
+	dir  string
+	perm permission
+	fs   fs.FS
+}
+
+// rootsFS is a file system with each of the named roots as a directory at the top level.
+type rootsFS []serverRoot
+
+func (rfs rootsFS) lookup(op, name string) (serverRoot, string, error) {
+	if !fs.ValidPath(name) {
+		return serverRoot{}, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	first, rest, _ := strings.Cut(name, "/")
+	if rest == "" {
+		rest = "."
+	}
+	for _, sr := range rfs {
+		if sr.name == first {
+			return sr, rest, nil
+		}
+	}
+	return serverRoot{}, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// fixPathError reports errors from a root using the full name, rather than the name relative
+// to the root.
+func fixPathError(name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+func (rfs rootsFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return &rootsDir{rfs: rfs}, nil
+	}
+
+	sr, rest, err := rfs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := sr.fs.Open(rest)
+	if err != nil {
+		return nil, fixPathError(name, err)
+	}
+	if rest == "." {
+		return rootFile{File: f, name: sr.name}, nil
+	}
+	return f, nil
+}
+
+func (rfs rootsFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == "." {
+		return rfs.entries(), nil
+	}
+
+	sr, rest, err := rfs.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(sr.fs, rest)
+	if err != nil {
+		return nil, fixPathError(name, err)
+	}
+	return entries, nil
+}
+
+func (rfs rootsFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return rootsDirInfo{}, nil
+	}
+
+	sr, rest, err := rfs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Stat(sr.fs, rest)
+	if err != nil {
+		return nil, fixPathError(name, err)
+	}
+	if rest == "." {
+		return rootInfo{FileInfo: fi, name: sr.name}, nil
+	}
+	return fi, nil
+}
+
+func (rfs rootsFS) Lstat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return rootsDirInfo{}, nil
+	}
+
+	sr, rest, err := rfs.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := lstat(sr.fs, rest)
+	if err != nil {
+		return nil, fixPathError(name, err)
+	}
+	if rest == "." {
+		return rootInfo{FileInfo: fi, name: sr.name}, nil
+	}
+	return fi, nil
+}
+
+func (rfs rootsFS) Readlink(name string) (string, error) {
+	sr, rest, err := rfs.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	target, err := readlink(sr.fs, rest)
+	if err != nil {
+		return "", fixPathError(name, err)
+	}
+	return target, nil
+}
+
+func (rfs rootsFS) Realpath(name string) (string, error) {
+	if name == "." {
+		return ".", nil
+	}
+
+	sr, rest, err := rfs.lookup("realpath", name)
+	if err != nil {
+		return "", err
+	}
+	rp, err := realpath(sr.fs, rest)
+	if err != nil {
+		return "", fixPathError(name, err)
+	}
+	return path.Join(sr.name, rp), nil
+}
+
+func (rfs rootsFS) entries() []fs.DirEntry {
+	var entries []fs.DirEntry
+	for _, sr := range rfs {
+		fi, err := fs.Stat(sr.fs, ".")
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(rootInfo{FileInfo: fi, name: sr.name}))
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries
+}
+
+// rootInfo is the file info of the top level directory of a root, named by the root.
+type rootInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (ri rootInfo) Name() string {
+	return ri.name
+}
+
+type rootFile struct {
+	fs.File
+	name string
+}
+
+func (rf rootFile) Stat() (fs.FileInfo, error) {
+	fi, err := rf.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return rootInfo{FileInfo: fi, name: rf.name}, nil
+}
+
+func (rf rootFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if rdf, ok := rf.File.(fs.ReadDirFile); ok {
+		return rdf.ReadDir(n)
+	}
+	return nil, &fs.PathError{Op: "readdir", Path: rf.name, Err: errors.New("not implemented")}
+}
+
+// rootsDir is the top level directory of a rootsFS, containing the roots.
+type rootsDir struct {
+	rfs     rootsFS
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (rd *rootsDir) Stat() (fs.FileInfo, error) {
+	return rootsDirInfo{}, nil
+}
+
+func (rd *rootsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (rd *rootsDir) Close() error {
+	return nil
+}
+
+func (rd *rootsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !rd.read {
+		rd.entries = rd.rfs.entries()
+		rd.read = true
+	}
+
+	if n <= 0 {
+		entries := rd.entries
+		rd.entries = nil
+		return entries, nil
+	}
+
+	if len(rd.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rd.entries))
+	entries := rd.entries[:n]
+	rd.entries = rd.entries[n:]
+	return entries, nil
+}
+
+type rootsDirInfo struct{}
+
+func (rootsDirInfo) Name() string       { return "." }
+func (rootsDirInfo) Size() int64        { return 0 }
+func (rootsDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (rootsDirInfo) ModTime() time.Time { return time.Time{} }
+func (rootsDirInfo) IsDir() bool        { return true }
+func (rootsDirInfo) Sys() any           { return nil }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRootsFS(t *testing.T) {
+	srcDir := t.TempDir()
+	docsDir := t.TempDir()
+
+	mustWriteFile(t, filepath.Join(srcDir, "main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(srcDir, "lib", "lib.go"), []byte("package lib"))
+	mustWriteFile(t, filepath.Join(docsDir, "readme.md"), []byte("readme"))
+
+	rfs := rootsFS{
+		{name: "src", dir: srcDir, perm: readWrite, fs: os.DirFS(srcDir)},
+		{name: "docs", dir: docsDir, perm: readOnly, fs: os.DirFS(docsDir)},
+	}
+
+	err := fstest.TestFS(rfs, "src/main.go", "src/lib/lib.go", "docs/readme.md")
+	if err != nil {
+		t.Errorf("TestFS() failed with %s", err)
+	}
+
+	ft := fileTools{fs: rfs}
+	ctx := context.Background()
+
+	cnt, err := ft.readFile(ctx, "src/lib/lib.go", maxFileSize)
+	if err != nil {
+		t.Errorf("readFile(src/lib/lib.go) failed with %s", err)
+	} else if string(cnt) != "package lib" {
+		t.Errorf("readFile(src/lib/lib.go) got %s, want package lib", cnt)
+	}
+
+	matches, err := ft.searchFiles(ctx, ".", "*.go")
+	if err != nil {
+		t.Errorf("searchFiles(*.go) failed with %s", err)
+	} else {
+		slices.Sort(matches)
+		want := []string{"src/lib/lib.go", "src/main.go"}
+		if !reflect.DeepEqual(matches, want) {
+			t.Errorf("searchFiles(*.go) got %v, want %v", matches, want)
+		}
+	}
+
+	mustFailPaths := []string{
+		"missing",
+		"missing/file.txt",
+		"src/missing.go",
+		"../src/main.go",
+		"/src/main.go",
+		"src/../../main.go",
+	}
+
+	for _, path := range mustFailPaths {
+		_, err := ft.readFile(ctx, path, maxFileSize)
+		if err == nil {
+			t.Errorf("readFile(%s) did not fail", path)
+		}
+	}
+
+	_, err = ft.readFile(ctx, "src/missing.go", maxFileSize)
+	var pe *fs.PathError
+	if !errors.As(err, &pe) || pe.Path != "src/missing.go" || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("readFile(src/missing.go) got %v, want not exist error", err)
+	}
+}
+
+func TestListRoots(t *testing.T) {
+	ft := fileTools{
+		serverRoots: []serverRoot{
+			{name: "src", dir: "/home/me/src", perm: readWrite},
+			{name: "log", dir: "/var/log", perm: readOnly},
+			{name: "etc", dir: "/etc", perm: readOnly},
+		},
+	}
+
+	roots := ft.listRoots(context.Background())
+	want := []rootEntry{
+		{Name: "src", Permission: "read-write"},
+		{Name: "log", Permission: "read-only"},
+		{Name: "etc", Permission: "read-only"},
+	}
+	if !reflect.DeepEqual(roots, want) {
+		t.Errorf("listRoots() got %v, want %v", roots, want)
+	}
+
+	// Only the roots which the session may use some of are listed.
+	ft.access = &sessionAccess{access: &access{Paths: []string{"src/cmd", "etc/hosts"}}}
+	ft.roots = newClientRoots()
+	ft.roots.setPaths([]string{"src", "log"})
+	roots = ft.listRoots(context.Background())
+	want = []rootEntry{{Name: "src", Permission: "read-write"}}
+	if !reflect.DeepEqual(roots, want) {
+		t.Errorf("listRoots() with access got %v, want %v", roots, want)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// sessionInfo describes who a session belongs to and how it connected.
+type sessionInfo struct {
+	identity   string
+	scopes     []string
+	transport  string
+	remoteAddr string
+}
+
+// serverFactory builds a server, with its own fileTools, for each session. The access
+// policy, the tools which require confirmation, and the bearer tokens may be reloaded.
+type serverFactory struct {
+	fs          fs.FS
+	ignore      []string
+	serverRoots []serverRoot
+	stateDir    string
+	protoLog    *protoLog
+	drainer     *drainer
+	deny        denyList
+	redactor    *redactor
+	audit       *auditLog
+	limiter     *limiter
+	maxBytes    int
+	maxItems    int
+	index       *nameIndex
+
+	mu       sync.Mutex
+	confirm  []string
+	policy   accessPolicy
+	tokens   []bearerToken
+	sessions map[*mcp.Server]fileTools
+}
+
+func (sf *serverFactory) newServer(si sessionInfo) *mcp.Server {
+	ft := fileTools{
+		fs:          sf.fs,
+		ignore:      sf.ignore,
+		roots:       newClientRoots(),
+		serverRoots: sf.serverRoots,
+		session:     si,
+		stateDir:    sf.stateDir,
+		access:      &sessionAccess{access: sf.lookup(si.identity)},
+		deny:        sf.deny,
+		redactor:    sf.redactor,
+		maxBytes:    sf.maxBytes,
+		maxItems:    sf.maxItems,
+		index:       sf.index,
+		cwd:         &workingDir{},
+	}
+
+	var srvr *mcp.Server
+	srvr = mcp.NewServer(&mcp.Implementation{
+		Name:    "filemcp",
+		Version: "0.1.0",
+	}, &mcp.ServerOptions{
+		// The tools of a session change when the access policy is reloaded, so always
+		// advertise tools, even if there aren't any yet.
+		Capabilities: &mcp.ServerCapabilities{
+			Logging: &mcp.LoggingCapabilities{},
+			Tools:   &mcp.ToolCapabilities{ListChanged: true},
+		},
+		CompletionHandler: ft.handleComplete,
+		InitializedHandler: func(ctx context.Context, req *mcp.InitializedRequest) {
+			sf.addSession(srvr, ft, req.Session)
+			ft.handleInitialized(ctx, req)
+		},
+		RootsListChangedHandler: ft.handleRootsListChanged,
+	})
+	ft.registerTools(srvr)
+	ft.registerPrompts(srvr)
+	if sf.drainer != nil {
+		srvr.AddReceivingMiddleware(sf.drainer.middleware)
+	}
+	srvr.AddReceivingMiddleware(toolErrors)
+	srvr.AddReceivingMiddleware(confirmTools(sf.confirmTools, ft.callPaths))
+	if sf.limiter != nil {
+		srvr.AddReceivingMiddleware(sf.limiter.middleware(si))
+	}
+	if sf.audit != nil {
+		srvr.AddReceivingMiddleware(sf.audit.middleware(si, ft.callPaths))
+	}
+	return srvr
+}
+
+func (sf *serverFactory) lookup(identity string) *access {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	return sf.policy.lookup(identity)
+}
+
+func (sf *serverFactory) confirmTools() []string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	return sf.confirm
+}
+
+func (sf *serverFactory) bearerTokens() []bearerToken {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	return sf.tokens
+}
+
+// addSession keeps track of the server and fileTools of a session, until it ends, so that
+// its access and tools can be updated when the access policy is reloaded.
+func (sf *serverFactory) addSession(srvr *mcp.Server, ft fileTools, ss *mcp.ServerSession) {
+	sf.mu.Lock()
+	if sf.sessions == nil {
+		sf.sessions = map[*mcp.Server]fileTools{}
+	}
+	sf.sessions[srvr] = ft
+	sf.mu.Unlock()
+
+	// The policy might have been reloaded since the server was built.
+	ft.access.set(sf.lookup(ft.session.identity))
+	ft.registerTools(srvr)
+
+	go func() {
+		ss.Wait()
+
+		sf.mu.Lock()
+		delete(sf.sessions, srvr)
+		sf.mu.Unlock()
+	}()
+}
+
+// reload replaces the access policy, the tools which require confirmation, and the bearer
+// tokens. The access and tools of each session are updated; sessions are notified when their
+// tools change.
+func (sf *serverFactory) reload(policy accessPolicy, confirm []string, tokens []bearerToken) {
+	sf.mu.Lock()
+	sf.policy = policy
+	sf.confirm = slices.Clone(confirm)
+	sf.tokens = tokens
+	sessions := map[*mcp.Server]fileTools{}
+	for srvr, ft := range sf.sessions {
+		sessions[srvr] = ft
+	}
+	sf.mu.Unlock()
+
+	for srvr, ft := range sessions {
+		ft.access.set(policy.lookup(ft.session.identity))
+		ft.registerTools(srvr)
+	}
+	slog.Info("reloaded configuration", "sessions", len(sessions))
+}
+
+// stdioServer returns the server of the stdio session. Its protocol messages are logged in
+// the same format, and to the same file, as those of HTTP sessions.
+func (sf *serverFactory) stdioServer() *mcp.Server {
+	srvr := sf.newServer(sessionInfo{transport: "stdio"})
+	if sf.protoLog != nil {
+		sf.protoLog.session("").addMiddleware(srvr)
+	}
+	return srvr
+}
+
+// getServer returns a function which builds a server for each new session of an HTTP
+// transport. No server is built once the server is shutting down.
+func (sf *serverFactory) getServer(transport string) func(r *http.Request) *mcp.Server {
+	return func(r *http.Request) *mcp.Server {
+		if sf.drainer != nil && sf.drainer.isClosing() {
+			slog.Info("refusing new session: shutting down", "transport", transport,
+				"remote", r.RemoteAddr)
+			return nil
+		}
+
+		si := sessionInfo{
+			identity:   requestIdentity(r),
+			scopes:     requestScopes(r),
+			transport:  transport,
+			remoteAddr: r.RemoteAddr,
+		}
+		slog.Info("new session", "transport", si.transport, "identity", si.identity,
+			"remote", si.remoteAddr)
+
+		srvr := sf.newServer(si)
+		if sf.protoLog != nil {
+			sf.protoLog.session(si.remoteAddr).addMiddleware(srvr)
+		}
+		return srvr
+	}
+}
+
+// requestIdentity returns the authenticated identity of the request, if any: the identity
+// of its bearer token or else of its verified client certificate.
+func requestIdentity(r *http.Request) string {
+	if ti := auth.TokenInfoFromContext(r.Context()); ti != nil {
+		return ti.UserID
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return certIdentity(r.TLS.VerifiedChains[0][0])
+	}
+	return ""
+}
+
+// requestScopes returns the scopes of the access token of the request. Nil means that the
+// request was not authorized with an access token, and is not limited by scopes.
+func requestScopes(r *http.Request) []string {
+	if ti := auth.TokenInfoFromContext(r.Context()); ti != nil {
+		return ti.Scopes
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestRequestIdentity(t *testing.T) {
+	verifier := func(ctx context.Context, token string, r *http.Request) (*auth.TokenInfo, error) {
+		return &auth.TokenInfo{UserID: token, Expiration: time.Now().Add(time.Hour)}, nil
+	}
+
+	var identity string
+	handler := auth.RequireBearerToken(verifier, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity = requestIdentity(r)
+		}))
+
+	r := httptest.NewRequest("GET", "/mcp", nil)
+	r.Header.Set("Authorization", "Bearer alice")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if identity != "alice" {
+		t.Errorf("requestIdentity() got %q, want alice", identity)
+	}
+
+	r = httptest.NewRequest("GET", "/mcp", nil)
+	if identity := requestIdentity(r); identity != "" {
+		t.Errorf("requestIdentity() got %q, want empty", identity)
+	}
+}
+
+func TestServerPerSession(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("content"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+	}
+
+	servers := map[*mcp.Server]bool{}
+	getServer := sf.getServer("http")
+	handler := mcp.NewStreamableHTTPHandler(func(r *http.Request) *mcp.Server {
+		srvr := getServer(r)
+		servers[srvr] = true
+		return srvr
+	}, nil)
+	httpServer := httptest.NewServer(handler)
+	defer httpServer.Close()
+
+	ctx := context.Background()
+	for range 2 {
+		client := mcp.NewClient(&mcp.Implementation{Name: "client", Version: "0.1.0"},
+			&mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}})
+		cs, err := client.Connect(ctx, &mcp.StreamableClientTransport{Endpoint: httpServer.URL},
+			nil)
+		if err != nil {
+			t.Fatalf("Connect() failed with %s", err)
+		}
+		defer cs.Close()
+
+		res := callTool(t, cs, "read_file", map[string]any{"path": "file.txt"})
+		if res.IsError {
+			t.Errorf("read_file(file.txt) failed with %v", res.Content)
+		}
+	}
+
+	if len(servers) != 2 {
+		t.Errorf("got %d servers, want 2", len(servers))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// drainer tracks sessions and their in-flight tool calls, so that on shutdown new sessions
+// and calls can be refused, the calls which are in flight can finish, and then the sessions
+// can be closed.
+type drainer struct {
+	mu       sync.Mutex
+	closing  bool
+	calls    int
+	idle     chan struct{}
+	sessions map[*mcp.ServerSession]struct{}
+}
+
+func (d *drainer) isClosing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.closing
+}
+
+// middleware tracks sessions, when they are initialized, and tool calls.
+func (d *drainer) middleware(next mcp.MethodHandler) mcp.MethodHandler {
+	return func(ctx context.Context, method string, req mcp.Request) (mcp.Result, error) {
+		switch method {
+		case "initialize":
+			if ss, ok := req.GetSession().(*mcp.ServerSession); ok {
+				d.addSession(ss)
+			}
+		case "tools/call":
+			if !d.startCall() {
+				setCallError(ctx, errShuttingDown)
+				return &mcp.CallToolResult{
+					Content: []mcp.Content{&mcp.TextContent{Text: errShuttingDown.Error()}},
+					IsError: true,
+				}, nil
+			}
+			defer d.endCall()
+		}
+		return next(ctx, method, req)
+	}
+}
+
+func (d *drainer) addSession(ss *mcp.ServerSession) {
+	d.mu.Lock()
+	if d.sessions == nil {
+		d.sessions = map[*mcp.ServerSession]struct{}{}
+	}
+	d.sessions[ss] = struct{}{}
+	d.mu.Unlock()
+
+	go func() {
+		ss.Wait()
+
+		d.mu.Lock()
+		delete(d.sessions, ss)
+		d.mu.Unlock()
+	}()
+}
+
+func (d *drainer) startCall() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closing {
+		return false
+	}
+	d.calls++
+	return true
+}
+
+func (d *drainer) endCall() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.calls--
+	if d.calls == 0 && d.idle != nil {
+		close(d.idle)
+		d.idle = nil
+	}
+}
+
+// drain refuses new sessions and tool calls, and waits for the tool calls in flight to
+// finish or for ctx to be done.
+func (d *drainer) drain(ctx context.Context) error {
+	d.mu.Lock()
+	d.closing = true
+	if d.calls == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	calls := d.calls
+	d.mu.Unlock()
+
+	slog.Info("draining tool calls", "calls", calls)
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeSessions closes all of the sessions.
+func (d *drainer) closeSessions() {
+	d.mu.Lock()
+	var sessions []*mcp.ServerSession
+	for ss := range d.sessions {
+		sessions = append(sessions, ss)
+	}
+	d.mu.Unlock()
+
+	for _, ss := range sessions {
+		slog.Info("closing session", "session", ss.ID())
+		ss.Close()
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type sleepInput struct{}
+
+type sleepOutput struct{}
+
+func TestDrainer(t *testing.T) {
+	d := &drainer{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	srvr := mcp.NewServer(&mcp.Implementation{Name: "test", Version: "0.1.0"}, nil)
+	mcp.AddTool(srvr, &mcp.Tool{Name: "sleep"},
+		func(ctx context.Context, req *mcp.CallToolRequest, args sleepInput) (*mcp.CallToolResult,
+			sleepOutput, error) {
+
+			started <- struct{}{}
+			<-release
+			return nil, sleepOutput{}, nil
+		})
+	srvr.AddReceivingMiddleware(d.middleware)
+	srvr.AddReceivingMiddleware(toolErrors)
+
+	_, cs := connectClient(t, srvr, nil)
+
+	done := make(chan *mcp.CallToolResult)
+	go func() {
+		done <- callTool(t, cs, "sleep", map[string]any{})
+	}()
+	<-started
+
+	// The call in flight doesn't finish before the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.drain(ctx); err == nil {
+		t.Errorf("drain() did not time out")
+	}
+
+	// New calls are refused while draining.
+	if res := callTool(t, cs, "sleep", map[string]any{}); !res.IsError {
+		t.Errorf("callTool(sleep) did not fail while draining")
+	} else if out, _ := res.StructuredContent.(map[string]any); out["code"] != "shutting_down" {
+		t.Errorf("callTool(sleep) got %v, want shutting_down", res.StructuredContent)
+	}
+
+	drained := make(chan error)
+	go func() {
+		drained <- d.drain(context.Background())
+	}()
+	close(release)
+	if res := <-done; res.IsError {
+		t.Errorf("callTool(sleep) failed with %v", res.Content)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("drain() failed with %s", err)
+	}
+
+	d.closeSessions()
+	waited := make(chan error)
+	go func() {
+		waited <- cs.Wait()
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Errorf("closeSessions() did not close the session")
+	}
+}
+
+func TestGetServerClosing(t *testing.T) {
+	sf := &serverFactory{
+		fs:          rootsFS(nil),
+		serverRoots: nil,
+		drainer:     &drainer{},
+	}
+
+	r := httptest.NewRequest("POST", "/mcp", nil)
+	if srvr := sf.getServer("http")(r); srvr == nil {
+		t.Errorf("getServer() got nil")
+	}
+
+	sf.drainer.drain(context.Background())
+	if srvr := sf.getServer("http")(r); srvr != nil {
+		t.Errorf("getServer() got a server while shutting down")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxSuggestions = 5
+	maxIndexFiles  = 100000
+	indexTTL       = time.Minute
+)
+
+// suggestError is an error about a path which doesn't exist, with the paths which the client
+// might have meant.
+type suggestError struct {
+	err         error
+	suggestions []string
+}
+
+func (se *suggestError) Error() string {
+	return se.err.Error()
+}
+
+func (se *suggestError) Unwrap() error {
+	return se.err
+}
+
+// nameIndex maps the names of the files and directories in the tree, in lower case, to their
+// paths. It is built when it is first used, and rebuilt in the background when it is older
+// than indexTTL.
+type nameIndex struct {
+	fs     fs.FS
+	ignore []string
+	deny   denyList
+
+	mu       sync.Mutex
+	built    time.Time
+	names    map[string][]string
+	building chan struct{}
+}
+
+func newNameIndex(fsys fs.FS, ignore []string, deny denyList) *nameIndex {
+	return &nameIndex{
+		fs:     fsys,
+		ignore: ignore,
+		deny:   deny,
+	}
+}
+
+// build walks the tree, without holding the lock, replaces the names, and closes done.
+func (ni *nameIndex) build(done chan struct{}) {
+	names := map[string][]string{}
+	var count int
+	err := fs.WalkDir(ni.fs, ".", func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			// Keep going: part of the tree is better than none of it.
+			return nil
+		} else if p == "." {
+			return nil
+		} else if ignored(ni.ignore, de.Name()) || ni.deny.denied(p) {
+			if de.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		name := strings.ToLower(de.Name())
+		names[name] = append(names[name], p)
+		count += 1
+		if count >= maxIndexFiles {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("build name index", "error", err)
+	}
+
+	ni.mu.Lock()
+	ni.names = names
+	ni.built = time.Now()
+	ni.building = nil
+	ni.mu.Unlock()
+	close(done)
+	slog.Info("built name index", "files", count)
+}
+
+// lookup returns the paths of the files and directories which are named name, ignoring case.
+// Until the index is first built, lookup waits for it, or for ctx to be done; after that, it
+// uses the current index while a newer one is built.
+func (ni *nameIndex) lookup(ctx context.Context, name string) []string {
+	if ni == nil {
+		return nil
+	}
+
+	ni.mu.Lock()
+	if ni.building == nil && (ni.names == nil || time.Since(ni.built) > indexTTL) {
+		ni.building = make(chan struct{})
+		go ni.build(ni.building)
+	}
+	names, building := ni.names, ni.building
+	ni.mu.Unlock()
+
+	if names == nil {
+		select {
+		case <-building:
+		case <-ctx.Done():
+			return nil
+		}
+
+		ni.mu.Lock()
+		names = ni.names
+		ni.mu.Unlock()
+	}
+	return names[strings.ToLower(name)]
+}
+
+// editDistance returns the edit distance between a and b: the number of characters which
+// must be inserted, deleted, replaced, or swapped with the next character to change a to b.
+func editDistance(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	row := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				row[j] = min(row[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, row = prev, row, prev2
+	}
+	return prev[len(rb)]
+}
+
+// similarNames returns the names in the directory dir which are the same as name, ignoring
+// case, or are within a small edit distance of it, closest first.
+func (ft fileTools) similarNames(dir, name string) []string {
+	lst, err := fs.ReadDir(ft.fs, dir)
+	if err != nil {
+		return nil
+	}
+
+	maxDist := 0
+	if len(name) >= 3 {
+		maxDist = 1 + len(name)/8
+	}
+
+	type similar struct {
+		name string
+		dist int
+	}
+	var names []similar
+	lower := strings.ToLower(name)
+	for _, de := range lst {
+		if ft.ignored(de.Name()) {
+			continue
+		}
+		if de.Name() == name {
+			return []string{name}
+		}
+
+		dist := editDistance(lower, strings.ToLower(de.Name()))
+		if dist <= maxDist {
+			names = append(names, similar{de.Name(), dist})
+		}
+	}
+
+	slices.SortStableFunc(names, func(a, b similar) int {
+		return a.dist - b.dist
+	})
+	var ret []string
+	for _, s := range names {
+		ret = append(ret, s.name)
+	}
+	return ret
+}
+
+// resolveSimilar returns the paths in dir which match elems, where each element may be
+// a similar name rather than the same name.
+func (ft fileTools) resolveSimilar(dir string, elems []string) []string {
+	if len(elems) == 0 {
+		return []string{dir}
+	}
+
+	var paths []string
+	for _, name := range ft.similarNames(dir, elems[0]) {
+		p := name
+		if dir != "." {
+			p = path.Join(dir, name)
+		}
+		paths = append(paths, ft.resolveSimilar(p, elems[1:])...)
+		if len(paths) >= maxSuggestions {
+			break
+		}
+	}
+	return paths
+}
+
+// suggest returns the paths, which the session may use, that the client might have meant by
+// p: paths which are the same except for case or small typos, and paths elsewhere in the
+// tree with the same name.
+func (ft fileTools) suggest(ctx context.Context, p string, use func(string) bool) []string {
+	p = path.Clean(p)
+	if !fs.ValidPath(p) || p == "." {
+		return nil
+	}
+
+	suggestions := ft.resolveSimilar(".", strings.Split(p, "/"))
+	suggestions = append(suggestions, ft.index.lookup(ctx, path.Base(p))...)
+
+	var ret []string
+	for _, s := range suggestions {
+		if s != p && !slices.Contains(ret, s) && use(s) {
+			ret = append(ret, s)
+			if len(ret) == maxSuggestions {
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// notFound returns err, with suggestions of the paths which the client might have meant, if
+// err is about p not existing.
+func (ft fileTools) notFound(ctx context.Context, p string, err error,
+	use func(string) bool) error {
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	suggestions := ft.suggest(ctx, p, use)
+	if len(suggestions) == 0 {
+		return err
+	}
+	return &suggestError{err: err, suggestions: suggestions}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		dist int
+	}{
+		{a: "", b: "", dist: 0},
+		{a: "abc", b: "", dist: 3},
+		{a: "", b: "abc", dist: 3},
+		{a: "main.go", b: "main.go", dist: 0},
+		{a: "main.go", b: "mian.go", dist: 1},
+		{a: "ca", b: "abc", dist: 3},
+		{a: "helper.go", b: "helpr.go", dist: 1},
+		{a: "kitten", b: "sitting", dist: 3},
+		{a: "naïve", b: "naive", dist: 1},
+	}
+
+	for _, c := range cases {
+		if dist := editDistance(c.a, c.b); dist != c.dist {
+			t.Errorf("editDistance(%s, %s) got %d, want %d", c.a, c.b, dist, c.dist)
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "src", "Main.go"), []byte("package main"))
+	mustWriteFile(t, filepath.Join(tempDir, "src", "util", "helper.go"), []byte("package util"))
+	mustWriteFile(t, filepath.Join(tempDir, "docs", "README.md"), []byte("readme"))
+	mustWriteFile(t, filepath.Join(tempDir, ".ssh", "readme.md"), []byte("keys"))
+	mustWriteFile(t, filepath.Join(tempDir, ".git", "helper.go"), []byte("package git"))
+
+	deny, err := parseDenyList(nil, true)
+	if err != nil {
+		t.Fatalf("parseDenyList() failed with %s", err)
+	}
+	fsys := os.DirFS(tempDir)
+	ft := fileTools{
+		fs:     fsys,
+		ignore: defaultIgnore,
+		deny:   deny,
+		index:  newNameIndex(fsys, defaultIgnore, deny),
+	}
+	use := func(p string) bool { return !deny.denied(p) }
+
+	cases := []struct {
+		path string
+		want []string
+	}{
+		{path: "src/main.go", want: []string{"src/Main.go"}},
+		{path: "SRC/MAIN.GO", want: []string{"src/Main.go"}},
+		{path: "src/mian.go", want: []string{"src/Main.go"}},
+		{path: "src/utl/helper.go", want: []string{"src/util/helper.go"}},
+		{path: "src/helper.go", want: []string{"src/util/helper.go"}},
+		{path: "readme.md", want: []string{"docs/README.md"}},
+		{path: "docs/readme.txt", want: nil},
+		{path: "missing.txt", want: nil},
+		{path: "../src/main.go", want: nil},
+	}
+
+	for _, c := range cases {
+		if got := ft.suggest(context.Background(), c.path, use); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("suggest(%s) got %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestSuggestTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "src", "Main.go"), []byte("package main"))
+
+	fsys := os.DirFS(tempDir)
+	sf := &serverFactory{
+		fs:          fsys,
+		serverRoots: []serverRoot{{dir: tempDir}},
+		index:       newNameIndex(fsys, defaultIgnore, nil),
+	}
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+	for _, tool := range []string{"read_file", "get_file_info"} {
+		res := callTool(t, cs, tool, map[string]any{"path": "src/main.go"})
+		if !res.IsError {
+			t.Errorf("%s(src/main.go) did not fail", tool)
+			continue
+		}
+
+		out := res.StructuredContent.(map[string]any)
+		if out["code"] != "not_found" ||
+			!reflect.DeepEqual(out["suggestions"], []any{"src/Main.go"}) {
+
+			t.Errorf("%s(src/main.go) got %v, want suggestion src/Main.go", tool, out)
+		}
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "src", "Main.go"), []byte("package main"))
+	ni := newNameIndex(os.DirFS(tempDir), defaultIgnore, nil)
+
+	// While the index is being built for the first time, lookup waits for it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ni.building = make(chan struct{})
+	if got := ni.lookup(ctx, "main.go"); got != nil {
+		t.Errorf("lookup(main.go) with a canceled context got %v", got)
+	}
+	ni.building = nil
+
+	if got := ni.lookup(context.Background(), "main.go"); !reflect.DeepEqual(got,
+		[]string{"src/Main.go"}) {
+
+		t.Errorf("lookup(main.go) got %v, want src/Main.go", got)
+	}
+
+	// An old index is used while a new one is built.
+	mustWriteFile(t, filepath.Join(tempDir, "docs", "main.go"), []byte("package docs"))
+	ni.mu.Lock()
+	ni.built = ni.built.Add(-2 * indexTTL)
+	ni.mu.Unlock()
+	if got := ni.lookup(context.Background(), "main.go"); !reflect.DeepEqual(got,
+		[]string{"src/Main.go"}) {
+
+		t.Errorf("lookup(main.go) got %v, want src/Main.go", got)
+	}
+
+	ni.mu.Lock()
+	building := ni.building
+	ni.mu.Unlock()
+	if building != nil {
+		select {
+		case <-building:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("name index was not rebuilt")
+		}
+	}
+	if got := ni.lookup(context.Background(), "main.go"); !reflect.DeepEqual(got,
+		[]string{"docs/main.go", "src/Main.go"}) {
+
+		t.Errorf("lookup(main.go) got %v, want docs/main.go and src/Main.go", got)
+	}
+}
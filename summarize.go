@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	summaryChunkSize  = 32 * 1024
+	summaryMaxChunks  = 64
+	summaryMergeBatch = 8
+	summaryMaxTokens  = 1024
+)
+
+// sampleFunc asks the client's model to respond to prompt.
+type sampleFunc func(ctx context.Context, prompt string) (string, error)
+
+type summarizeFileInput struct {
+	Path string `json:"path" jsonschema:"path to the file relative to the current directory, or an absolute path within the root directory"`
+}
+
+type summarizeFileOutput struct {
+	Path       string `json:"path" jsonschema:"the path that was summarized"`
+	RelPath    string `json:"relPath,omitempty" jsonschema:"the path relative to the current directory, if it is not the root directory"`
+	Summary    string `json:"summary" jsonschema:"summary of the file contents"`
+	Size       int    `json:"size" jsonschema:"size of the file in bytes"`
+	Chunks     int    `json:"chunks" jsonschema:"number of chunks the file was summarized in"`
+	Cached     bool   `json:"cached" jsonschema:"true if the summary was already cached"`
+	Redactions int    `json:"redactions,omitempty" jsonschema:"number of secrets which were redacted before summarizing"`
+}
+
+func (ft fileTools) handleSummarizeFile(ctx context.Context, req *mcp.CallToolRequest,
+	args summarizeFileInput) (*mcp.CallToolResult, summarizeFileOutput, error) {
+
+	slog.Info("summarize file", "args", args)
+
+	p, err := ft.resolvePath(args.Path)
+	if err != nil {
+		return nil, summarizeFileOutput{}, err
+	}
+	args.Path = p
+
+	err = ft.checkAllowed(ctx, args.Path)
+	if err != nil {
+		return nil, summarizeFileOutput{}, err
+	}
+
+	cnt, err := ft.readFile(ctx, args.Path, summaryMaxChunks*summaryChunkSize)
+	if err != nil {
+		return nil, summarizeFileOutput{}, err
+	}
+
+	// A cached summary is returned even if the client doesn't support sampling.
+	var sample sampleFunc
+	params := req.Session.InitializeParams()
+	if params != nil && params.Capabilities != nil && params.Capabilities.Sampling != nil {
+		sample = sessionSample(req.Session)
+	}
+
+	// Secrets are redacted before the contents are sent to the client's model.
+	content, redactions := ft.redact(args.Path, cnt)
+	summary, chunks, cached, err := ft.summarize(ctx, []byte(content), sample)
+	if err != nil {
+		return nil, summarizeFileOutput{}, err
+	}
+
+	return nil, summarizeFileOutput{
+		Path:       args.Path,
+		RelPath:    ft.cwdRelative(args.Path),
+		Summary:    summary,
+		Size:       len(cnt),
+		Chunks:     chunks,
+		Cached:     cached,
+		Redactions: redactions,
+	}, nil
+}
+
+// sessionSample returns a sampleFunc which uses the client of ss.
+func sessionSample(ss *mcp.ServerSession) sampleFunc {
+	return func(ctx context.Context, prompt string) (string, error) {
+		res, err := ss.CreateMessage(ctx, &mcp.CreateMessageParams{
+			Messages: []*mcp.SamplingMessage{
+				{
+					Role:    "user",
+					Content: &mcp.TextContent{Text: prompt},
+				},
+			},
+			SystemPrompt: "You summarize files for another model. Be accurate and concise.",
+			MaxTokens:    summaryMaxTokens,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		tc, ok := res.Content.(*mcp.TextContent)
+		if !ok {
+			return "", fmt.Errorf("sampling returned %T, not text", res.Content)
+		}
+		return tc.Text, nil
+	}
+}
+
+// summarize returns a summary of cnt: each chunk of cnt is summarized, and then the summaries
+// are merged, at most summaryMergeBatch at a time. Summaries are cached in the state
+// directory, keyed by the identity of the session and the hash of cnt, so that a summary
+// made by the model of one identity's client is not returned to another identity. If sample
+// is nil, only a cached summary can be returned.
+func (ft fileTools) summarize(ctx context.Context, cnt []byte,
+	sample sampleFunc) (string, int, bool, error) {
+
+	if !utf8.Valid(cnt) {
+		return "", 0, false, errors.New("not a text file")
+	}
+
+	chunks := splitChunks(cnt, summaryChunkSize)
+
+	h := sha256.New()
+	h.Write([]byte(ft.session.identity))
+	h.Write([]byte{0})
+	h.Write(cnt)
+	key := hex.EncodeToString(h.Sum(nil))
+	if summary, ok := ft.cachedSummary(key); ok {
+		return summary, len(chunks), true, nil
+	}
+
+	if len(chunks) > summaryMaxChunks {
+		return "", 0, false, fmt.Errorf("more than %d parts of %d KB to summarize: %w",
+			summaryMaxChunks, summaryChunkSize>>10, errTooLarge)
+	} else if sample == nil {
+		return "", 0, false, errors.New("client does not support sampling")
+	}
+
+	var summaries []string
+	for i, chunk := range chunks {
+		summary, err := sample(ctx, fmt.Sprintf(
+			"Summarize part %d of %d of a file. Describe what it contains, its structure, "+
+				"and anything notable.\n\n%s", i+1, len(chunks), chunk))
+		if err != nil {
+			return "", 0, false, err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	for len(summaries) > 1 {
+		var merged []string
+		for len(summaries) > 0 {
+			n := min(len(summaries), summaryMergeBatch)
+			summary, err := mergeSummaries(ctx, summaries[:n], len(merged) == 0 &&
+				n == len(summaries), sample)
+			if err != nil {
+				return "", 0, false, err
+			}
+			merged = append(merged, summary)
+			summaries = summaries[n:]
+		}
+		summaries = merged
+	}
+
+	ft.cacheSummary(key, summaries[0])
+	return summaries[0], len(chunks), false, nil
+}
+
+// mergeSummaries returns a single summary of summaries, which are of consecutive parts of a
+// file, or of the whole file, if whole.
+func mergeSummaries(ctx context.Context, summaries []string, whole bool,
+	sample sampleFunc) (string, error) {
+
+	if len(summaries) == 1 {
+		return summaries[0], nil
+	}
+
+	of := "those parts"
+	if whole {
+		of = "the whole file"
+	}
+	return sample(ctx, fmt.Sprintf(
+		"The following are summaries of %d consecutive parts of a file, in order. Merge them "+
+			"into a single summary of %s.\n\n%s", len(summaries), of,
+		strings.Join(summaries, "\n\n---\n\n")))
+}
+
+// splitChunks splits cnt into chunks of at most size bytes, breaking at the end of a line
+// when possible, and never in the middle of a UTF-8 sequence.
+func splitChunks(cnt []byte, size int) [][]byte {
+	if len(cnt) == 0 {
+		return [][]byte{cnt}
+	}
+
+	var chunks [][]byte
+	for len(cnt) > size {
+		n := bytes.LastIndexByte(cnt[:size], '\n') + 1
+		if n == 0 {
+			n = size
+			for n > 0 && !utf8.RuneStart(cnt[n]) {
+				n--
+			}
+		}
+		chunks = append(chunks, cnt[:n])
+		cnt = cnt[n:]
+	}
+	if len(cnt) > 0 {
+		chunks = append(chunks, cnt)
+	}
+	return chunks
+}
+
+func (ft fileTools) summaryPath(key string) string {
+	return filepath.Join(ft.stateDir, "summaries", key)
+}
+
+func (ft fileTools) cachedSummary(key string) (string, bool) {
+	if ft.stateDir == "" {
+		return "", false
+	}
+
+	summary, err := os.ReadFile(ft.summaryPath(key))
+	if err != nil {
+		return "", false
+	}
+	return string(summary), true
+}
+
+func (ft fileTools) cacheSummary(key string, summary string) {
+	if ft.stateDir == "" {
+		return
+	}
+
+	err := writeFileAtomic(ft.summaryPath(key), []byte(summary), 0600)
+	if err != nil {
+		slog.Error("cache summary", "key", key, "error", err)
+	}
+}
+
+// writeFileAtomic writes cnt to a temporary file in the same directory as path, and then
+// renames it to path, creating the directory if necessary.
+func writeFileAtomic(path string, cnt []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(cnt)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
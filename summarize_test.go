@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestSplitChunks(t *testing.T) {
+	cases := []struct {
+		cnt    string
+		size   int
+		chunks []string
+	}{
+		{cnt: "", size: 10, chunks: []string{""}},
+		{cnt: "short", size: 10, chunks: []string{"short"}},
+		{cnt: "exactly 10", size: 10, chunks: []string{"exactly 10"}},
+		{cnt: "line 1\nline 2\nline 3\n", size: 10,
+			chunks: []string{"line 1\n", "line 2\n", "line 3\n"}},
+		{cnt: "line 1\nline 2\nline 3", size: 14, chunks: []string{"line 1\nline 2\n", "line 3"}},
+		{cnt: "abcdefghijklmnopqrstuvwxyz", size: 10,
+			chunks: []string{"abcdefghij", "klmnopqrst", "uvwxyz"}},
+		{cnt: "ééééé", size: 5, chunks: []string{"éé", "éé", "é"}},
+	}
+
+	for _, c := range cases {
+		var chunks []string
+		for _, chunk := range splitChunks([]byte(c.cnt), c.size) {
+			chunks = append(chunks, string(chunk))
+		}
+		if strings.Join(chunks, "|") != strings.Join(c.chunks, "|") ||
+			len(chunks) != len(c.chunks) {
+
+			t.Errorf("splitChunks(%q, %d) got %q, want %q", c.cnt, c.size, chunks, c.chunks)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var prompts []string
+	sample := func(ctx context.Context, prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		return fmt.Sprintf("summary %d", len(prompts)), nil
+	}
+
+	ft := fileTools{stateDir: t.TempDir()}
+	ctx := context.Background()
+
+	cases := []struct {
+		cnt     []byte
+		summary string
+		chunks  int
+		cached  bool
+		samples int
+	}{
+		{cnt: []byte("small file"), summary: "summary 1", chunks: 1, samples: 1},
+		{cnt: []byte("small file"), summary: "summary 1", chunks: 1, cached: true, samples: 1},
+		{cnt: []byte("another file"), summary: "summary 2", chunks: 1, samples: 2},
+		{cnt: bytes.Repeat([]byte("0123456789abcde\n"), summaryChunkSize/16*3),
+			summary: "summary 6", chunks: 3, samples: 6},
+		{cnt: bytes.Repeat([]byte("0123456789abcde\n"), summaryChunkSize/16*3),
+			summary: "summary 6", chunks: 3, cached: true, samples: 6},
+	}
+
+	for i, c := range cases {
+		summary, chunks, cached, err := ft.summarize(ctx, c.cnt, sample)
+		if err != nil {
+			t.Errorf("summarize(%d) failed with %s", i, err)
+		} else if summary != c.summary || chunks != c.chunks || cached != c.cached {
+			t.Errorf("summarize(%d) got %s, %d, %v, want %s, %d, %v", i, summary, chunks, cached,
+				c.summary, c.chunks, c.cached)
+		} else if len(prompts) != c.samples {
+			t.Errorf("summarize(%d) sampled %d times, want %d", i, len(prompts), c.samples)
+		}
+	}
+
+	if !strings.Contains(prompts[5], "summary 3\n\n---\n\nsummary 4\n\n---\n\nsummary 5") {
+		t.Errorf("summarize() merge prompt missing chunk summaries: %s", prompts[5])
+	}
+
+	_, _, _, err := ft.summarize(ctx, []byte{0xff, 0xfe, 0x00}, sample)
+	if err == nil {
+		t.Errorf("summarize(binary) did not fail")
+	}
+
+	failSample := func(ctx context.Context, prompt string) (string, error) {
+		return "", errors.New("sampling failed")
+	}
+	_, _, _, err = ft.summarize(ctx, []byte("not cached"), failSample)
+	if err == nil {
+		t.Errorf("summarize(not cached) did not fail")
+	}
+
+	// Only a cached summary is returned without sampling.
+	summary, _, cached, err := ft.summarize(ctx, []byte("small file"), nil)
+	if err != nil {
+		t.Errorf("summarize(small file) without sampling failed with %s", err)
+	} else if summary != "summary 1" || !cached {
+		t.Errorf("summarize(small file) without sampling got %s, %v, want summary 1, true",
+			summary, cached)
+	}
+	_, _, _, err = ft.summarize(ctx, []byte("not cached"), nil)
+	if err == nil {
+		t.Errorf("summarize(not cached) without sampling did not fail")
+	}
+
+	// Summaries are not shared between identities.
+	other := fileTools{stateDir: ft.stateDir, session: sessionInfo{identity: "other"}}
+	_, _, cached, err = other.summarize(ctx, []byte("small file"), sample)
+	if err != nil {
+		t.Errorf("summarize(small file) as other failed with %s", err)
+	} else if cached {
+		t.Errorf("summarize(small file) as other got a cached summary")
+	}
+
+	// Files with too many chunks are not summarized.
+	samples := len(prompts)
+	_, _, _, err = ft.summarize(ctx, bytes.Repeat([]byte("0123456789abcde\n"),
+		summaryChunkSize/16*(summaryMaxChunks+1)), sample)
+	if !errors.Is(err, errTooLarge) {
+		t.Errorf("summarize(too large) got %v, want %s", err, errTooLarge)
+	} else if len(prompts) != samples {
+		t.Errorf("summarize(too large) sampled %d times", len(prompts)-samples)
+	}
+
+	// Chunk summaries are merged summaryMergeBatch at a time.
+	_, chunks, _, err := ft.summarize(ctx, bytes.Repeat([]byte("0123456789ABCDE\n"),
+		summaryChunkSize/16*(summaryMergeBatch+2)), sample)
+	if err != nil {
+		t.Errorf("summarize(batches) failed with %s", err)
+	} else if chunks != summaryMergeBatch+2 {
+		t.Errorf("summarize(batches) got %d chunks, want %d", chunks, summaryMergeBatch+2)
+	} else if n := len(prompts) - samples; n != chunks+3 {
+		t.Errorf("summarize(batches) sampled %d times, want %d", n, chunks+3)
+	} else if merge := prompts[len(prompts)-1]; !strings.Contains(merge, "2 consecutive") ||
+		!strings.Contains(merge, "the whole file") {
+
+		t.Errorf("summarize(batches) got final merge prompt %s", merge)
+	}
+
+	ft = fileTools{}
+	_, _, cached, err = ft.summarize(ctx, []byte("small file"), sample)
+	if err != nil {
+		t.Errorf("summarize(small file) failed with %s", err)
+	} else if cached {
+		t.Errorf("summarize(small file) cached without a state directory")
+	}
+}
+
+func TestSummarizeFileTool(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "file.txt"), []byte("file contents"))
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		stateDir:    t.TempDir(),
+	}
+
+	_, cs := connectClient(t, sf.newServer(sessionInfo{transport: "test"}),
+		&mcp.ClientOptions{
+			Capabilities: &mcp.ClientCapabilities{},
+			CreateMessageHandler: func(ctx context.Context,
+				req *mcp.CreateMessageRequest) (*mcp.CreateMessageResult, error) {
+
+				return &mcp.CreateMessageResult{
+					Content: &mcp.TextContent{Text: "a short file"},
+					Model:   "test",
+					Role:    "assistant",
+				}, nil
+			},
+		})
+
+	res := callTool(t, cs, "summarize_file", map[string]any{"path": "file.txt"})
+	if res.IsError {
+		t.Fatalf("summarize_file(file.txt) failed with %v", res.Content)
+	}
+	if out := res.StructuredContent.(map[string]any); out["summary"] != "a short file" {
+		t.Errorf("summarize_file(file.txt) got %v, want a short file", out["summary"])
+	}
+
+	mustWriteFile(t, filepath.Join(tempDir, "other.txt"), []byte("other contents"))
+	_, cs = connectClient(t, sf.newServer(sessionInfo{transport: "test"}),
+		&mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}})
+	res = callTool(t, cs, "summarize_file", map[string]any{"path": "file.txt"})
+	if res.IsError {
+		t.Errorf("summarize_file(file.txt) without sampling failed with %v", res.Content)
+	} else if out := res.StructuredContent.(map[string]any); out["cached"] != true {
+		t.Errorf("summarize_file(file.txt) without sampling got %v", out)
+	}
+	res = callTool(t, cs, "summarize_file", map[string]any{"path": "other.txt"})
+	if !res.IsError {
+		t.Errorf("summarize_file(other.txt) without sampling did not fail")
+	}
+}
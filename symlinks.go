@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// symlinkPolicy is which symbolic links are followed.
+type symlinkPolicy int
+
+const (
+	// followInRoot follows symbolic links to paths within the same root.
+	followInRoot symlinkPolicy = iota
+	// followNever doesn't follow any symbolic links.
+	followNever
+	// followAllowed follows symbolic links to paths within the same root, and to paths
+	// within the allowed directories.
+	followAllowed
+)
+
+func parseSymlinkPolicy(s string) (symlinkPolicy, error) {
+	switch s {
+	case "", "inroot":
+		return followInRoot, nil
+	case "never":
+		return followNever, nil
+	case "allow":
+		return followAllowed, nil
+	}
+	return 0, fmt.Errorf("unknown symlink policy: %s: expected inroot, never, or allow", s)
+}
+
+var errSymlink = errors.New("symbolic link not followed")
+
+// pathEscapes returns true if err is from following a symbolic link out of a root.
+func pathEscapes(err error) bool {
+	return strings.Contains(err.Error(), "path escapes from parent")
+}
+
+// rootFS is the file system of a server root, which follows symbolic links according to the
+// symlink policy. Symbolic links which escape from the root are only followed if the policy
+// is followAllowed and they resolve to a path within one of the allowed directories which
+// is not denied; the path is then opened through the allowed directory, so it can't escape
+// from it either.
+type rootFS struct {
+	root    *os.Root
+	fs      fs.FS
+	dir     string
+	realDir string
+	policy  symlinkPolicy
+	allowed []*rootFS
+	deny    denyList
+}
+
+func newRootFS(root *os.Root, dir string, policy symlinkPolicy, allowed []*rootFS,
+	deny denyList) *rootFS {
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		realDir = dir
+	}
+	return &rootFS{
+		root:    root,
+		fs:      root.FS(),
+		dir:     dir,
+		realDir: realDir,
+		policy:  policy,
+		allowed: allowed,
+		deny:    deny,
+	}
+}
+
+// check returns an error if the policy is followNever and name, or one of its parents,
+// is a symbolic link. If parents, only the parents of name are checked.
+func (rfs *rootFS) check(op, name string, parents bool) error {
+	if rfs.policy != followNever || name == "." {
+		return nil
+	}
+
+	elems := strings.Split(name, "/")
+	if parents {
+		elems = elems[:len(elems)-1]
+	}
+	for i := range elems {
+		fi, err := rfs.root.Lstat(path.Join(elems[:i+1]...))
+		if err != nil {
+			// Let the operation report the error.
+			return nil
+		} else if fi.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: op, Path: name, Err: errSymlink}
+		}
+	}
+	return nil
+}
+
+// resolveAllowed returns the allowed directory which name leads to, and the path within it,
+// if the policy is followAllowed. If the path is denied, it fails with errPolicyDenied.
+// Otherwise, it returns err, which is the error from following name within the root.
+func (rfs *rootFS) resolveAllowed(op, name string, err error) (*rootFS, string, error) {
+	if rfs.policy != followAllowed || !pathEscapes(err) {
+		return nil, "", err
+	}
+
+	target, outside, rerr := rfs.resolve(name)
+	if rerr != nil || !outside {
+		return nil, "", err
+	}
+	for _, afs := range rfs.allowed {
+		rel, ok := relativePath(afs.dir, target)
+		if !ok {
+			rel, ok = relativePath(afs.realDir, target)
+			if !ok {
+				continue
+			}
+		}
+
+		rp, rerr := afs.Realpath(filepath.ToSlash(rel))
+		if rerr != nil {
+			return nil, "", err
+		} else if rfs.deny.denied(filepath.ToSlash(filepath.Join(afs.realDir, rp))) {
+			return nil, "", &fs.PathError{Op: op, Path: name, Err: errPolicyDenied}
+		}
+		return afs, rp, nil
+	}
+	return nil, "", err
+}
+
+func (rfs *rootFS) Open(name string) (fs.File, error) {
+	err := rfs.check("open", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := rfs.fs.Open(name)
+	if err != nil {
+		afs, rp, err := rfs.resolveAllowed("open", name, err)
+		if err != nil {
+			return nil, err
+		}
+		return afs.Open(rp)
+	}
+	return f, nil
+}
+
+func (rfs *rootFS) Stat(name string) (fs.FileInfo, error) {
+	err := rfs.check("stat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := fs.Stat(rfs.fs, name)
+	if err != nil {
+		afs, rp, err := rfs.resolveAllowed("stat", name, err)
+		if err != nil {
+			return nil, err
+		}
+		return afs.Stat(rp)
+	}
+	return fi, nil
+}
+
+func (rfs *rootFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	err := rfs.check("readdir", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(rfs.fs, name)
+	if err != nil {
+		afs, rp, err := rfs.resolveAllowed("readdir", name, err)
+		if err != nil {
+			return nil, err
+		}
+		return afs.ReadDir(rp)
+	}
+	return entries, nil
+}
+
+// Lstat returns the file info of name without following it, if it is a symbolic link.
+func (rfs *rootFS) Lstat(name string) (fs.FileInfo, error) {
+	err := rfs.check("lstat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	return rfs.root.Lstat(name)
+}
+
+// Readlink returns the target of the symbolic link name.
+func (rfs *rootFS) Readlink(name string) (string, error) {
+	fi, err := rfs.Lstat(name)
+	if err != nil {
+		return "", err
+	} else if fi.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	target, err := os.Readlink(filepath.Join(rfs.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", fixPathError(name, err)
+	}
+	return target, nil
+}
+
+// maxLinks is the most symbolic links which are followed resolving a path.
+const maxLinks = 40
+
+var errTooManyLinks = errors.New("too many levels of symbolic links")
+
+// Realpath returns the path, relative to the root, which name leads to by following the
+// symbolic links in it, whatever the policy. The parts of name which don't exist are left as
+// they are. Symbolic links which lead out of the root fail with errPathEscape.
+func (rfs *rootFS) Realpath(name string) (string, error) {
+	rp, outside, err := rfs.resolve(name)
+	if err != nil {
+		return "", err
+	} else if outside {
+		return "", &fs.PathError{Op: "realpath", Path: name, Err: errPathEscape}
+	}
+	return rp, nil
+}
+
+// resolve returns the path, relative to the root, which name leads to by following the
+// symbolic links in it. If name leads out of the root, it returns the absolute path outside
+// of the root, and true.
+func (rfs *rootFS) resolve(name string) (string, bool, error) {
+	if !fs.ValidPath(name) {
+		return "", false, &fs.PathError{Op: "realpath", Path: name, Err: fs.ErrInvalid}
+	}
+
+	var resolved []string
+	pending := strings.Split(name, "/")
+	for links := 0; len(pending) > 0; {
+		elem := pending[0]
+		pending = pending[1:]
+		if elem == "." || elem == "" {
+			continue
+		} else if elem == ".." {
+			if len(resolved) == 0 {
+				return filepath.Join(append([]string{filepath.Dir(rfs.realDir)}, pending...)...),
+					true, nil
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		p := path.Join(path.Join(resolved...), elem)
+		fi, err := rfs.root.Lstat(p)
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = append(resolved, elem)
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return "", false, &fs.PathError{Op: "realpath", Path: name, Err: errTooManyLinks}
+		}
+		target, err := os.Readlink(filepath.Join(rfs.dir, filepath.FromSlash(p)))
+		if err != nil {
+			return "", false, fixPathError(name, err)
+		}
+
+		if filepath.IsAbs(target) {
+			rel, ok := relativePath(rfs.dir, target)
+			if !ok {
+				rel, ok = relativePath(rfs.realDir, target)
+				if !ok {
+					return filepath.Join(append([]string{target}, pending...)...), true, nil
+				}
+			}
+			resolved = nil
+			target = rel
+		}
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+
+	if len(resolved) == 0 {
+		return ".", false, nil
+	}
+	return path.Join(resolved...), false, nil
+}
+
+// linkFS is a file system which can report symbolic links rather than following them.
+type linkFS interface {
+	Lstat(name string) (fs.FileInfo, error)
+	Readlink(name string) (string, error)
+	Realpath(name string) (string, error)
+}
+
+// lstat returns the file info of name in fsys, without following it if it is a symbolic link
+// and fsys can report symbolic links.
+func lstat(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if lfs, ok := fsys.(linkFS); ok {
+		return lfs.Lstat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// readlink returns the target of the symbolic link name in fsys.
+func readlink(fsys fs.FS, name string) (string, error) {
+	if lfs, ok := fsys.(linkFS); ok {
+		return lfs.Readlink(name)
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+}
+
+// realpath returns the path which name leads to in fsys by following symbolic links, or name
+// if fsys can't report symbolic links.
+func realpath(fsys fs.FS, name string) (string, error) {
+	if lfs, ok := fsys.(linkFS); ok {
+		return lfs.Realpath(name)
+	}
+	return name, nil
+}
+
+// symlinkInfo returns the target of the symbolic link p, and whether or not it is followed.
+// Symbolic links to paths which the session may not use are not followed. Absolute targets
+// are returned as the path in the tree which p leads to, or not at all if p leads out of it,
+// so that paths on the server are not revealed.
+func (ft fileTools) symlinkInfo(p string) (string, bool) {
+	target, err := readlink(ft.fs, p)
+	if err != nil {
+		return "", false
+	}
+	if filepath.IsAbs(target) {
+		target, err = realpath(ft.fs, p)
+		if err != nil {
+			target = ""
+		}
+	}
+
+	_, err = fs.Stat(ft.fs, p)
+	if err != nil {
+		return target, !(pathEscapes(err) || errors.Is(err, errSymlink) ||
+			errors.Is(err, errPolicyDenied))
+	}
+	return target, ft.allowed(ft.realPath(p))
+}
+
+// openAllowedDirs opens each of dirs, which symbolic links may lead to, and returns their
+// file systems.
+func openAllowedDirs(dirs []string) ([]*rootFS, func(), error) {
+	var allowed []*rootFS
+	closeDirs := func() {
+		for _, afs := range allowed {
+			afs.root.Close()
+		}
+	}
+
+	for _, dir := range dirs {
+		dir, err := rootDirectory(dir)
+		if err != nil {
+			closeDirs()
+			return nil, nil, err
+		}
+		root, err := os.OpenRoot(dir)
+		if err != nil {
+			closeDirs()
+			return nil, nil, err
+		}
+		allowed = append(allowed, newRootFS(root, dir, followInRoot, nil, nil))
+	}
+	return allowed, closeDirs, nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	cases := []struct {
+		s      string
+		policy symlinkPolicy
+		fail   bool
+	}{
+		{s: "", policy: followInRoot},
+		{s: "inroot", policy: followInRoot},
+		{s: "never", policy: followNever},
+		{s: "allow", policy: followAllowed},
+		{s: "always", fail: true},
+	}
+
+	for _, c := range cases {
+		policy, err := parseSymlinkPolicy(c.s)
+		if c.fail {
+			if err == nil {
+				t.Errorf("parseSymlinkPolicy(%s) did not fail", c.s)
+			}
+		} else if err != nil {
+			t.Errorf("parseSymlinkPolicy(%s) failed with %s", c.s, err)
+		} else if policy != c.policy {
+			t.Errorf("parseSymlinkPolicy(%s) got %d, want %d", c.s, policy, c.policy)
+		}
+	}
+}
+
+func mustSymlink(t *testing.T, target, link string) {
+	t.Helper()
+
+	err := os.Symlink(target, link)
+	if err != nil {
+		t.Fatalf("Symlink(%s, %s) failed with %s", target, link, err)
+	}
+}
+
+// symlinkTree returns a root directory with symbolic links in it, and a directory outside of
+// the root which is allowed.
+func symlinkTree(t *testing.T) (string, string) {
+	t.Helper()
+
+	rootDir := filepath.Join(t.TempDir(), "root")
+	allowedDir := filepath.Join(t.TempDir(), "allowed")
+	otherDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(rootDir, "dir", "file.txt"), []byte("file"))
+	mustWriteFile(t, filepath.Join(allowedDir, "shared.txt"), []byte("shared"))
+	mustWriteFile(t, filepath.Join(otherDir, "secret.txt"), []byte("secret"))
+
+	mustSymlink(t, "dir/file.txt", filepath.Join(rootDir, "inlink"))
+	mustSymlink(t, "dir", filepath.Join(rootDir, "dirlink"))
+	mustSymlink(t, filepath.Join(allowedDir, "shared.txt"), filepath.Join(rootDir, "allowlink"))
+	mustSymlink(t, allowedDir, filepath.Join(rootDir, "allowdir"))
+	mustSymlink(t, filepath.Join(otherDir, "secret.txt"), filepath.Join(rootDir, "outlink"))
+	mustSymlink(t, "missing.txt", filepath.Join(rootDir, "dangling"))
+	return rootDir, allowedDir
+}
+
+func openRootFS(t *testing.T, dir string, policy symlinkPolicy, allowed []string) *rootFS {
+	t.Helper()
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatalf("OpenRoot(%s) failed with %s", dir, err)
+	}
+	t.Cleanup(func() { root.Close() })
+
+	allowedFS, closeAllowed, err := openAllowedDirs(allowed)
+	if err != nil {
+		t.Fatalf("openAllowedDirs(%v) failed with %s", allowed, err)
+	}
+	t.Cleanup(closeAllowed)
+
+	deny, err := parseDenyList(nil, true)
+	if err != nil {
+		t.Fatalf("parseDenyList() failed with %s", err)
+	}
+	return newRootFS(root, dir, policy, allowedFS, deny)
+}
+
+func TestRootFS(t *testing.T) {
+	rootDir, allowedDir := symlinkTree(t)
+	otherDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(allowedDir, "private", ".ssh", "id_ed25519"), []byte("key"))
+	mustWriteFile(t, filepath.Join(otherDir, "secret.txt"), []byte("secret"))
+	mustSymlink(t, filepath.Join(allowedDir, "private", ".ssh", "id_ed25519"),
+		filepath.Join(rootDir, "allowkey"))
+	mustSymlink(t, ".ssh", filepath.Join(allowedDir, "private", "keys"))
+	mustSymlink(t, filepath.Join(allowedDir, "private", "keys", "id_ed25519"),
+		filepath.Join(rootDir, "allowkeys"))
+	mustSymlink(t, filepath.Join(otherDir, "secret.txt"),
+		filepath.Join(allowedDir, "private", "secret.txt"))
+	mustSymlink(t, filepath.Join(allowedDir, "private", "secret.txt"),
+		filepath.Join(rootDir, "allowout"))
+	rel, err := filepath.Rel(filepath.Join(rootDir, "dir"), filepath.Join(allowedDir, "shared.txt"))
+	if err != nil {
+		t.Fatalf("Rel() failed with %s", err)
+	}
+	mustSymlink(t, rel, filepath.Join(rootDir, "dir", "relallow"))
+
+	type result int
+	const (
+		ok result = iota
+		escapes
+		notFollowed
+		notFound
+		denied
+	)
+
+	cases := []struct {
+		name   string
+		policy symlinkPolicy
+		want   result
+	}{
+		{name: "dir/file.txt", policy: followInRoot, want: ok},
+		{name: "inlink", policy: followInRoot, want: ok},
+		{name: "dirlink/file.txt", policy: followInRoot, want: ok},
+		{name: "allowlink", policy: followInRoot, want: escapes},
+		{name: "outlink", policy: followInRoot, want: escapes},
+		{name: "dangling", policy: followInRoot, want: notFound},
+
+		{name: "dir/file.txt", policy: followNever, want: ok},
+		{name: "inlink", policy: followNever, want: notFollowed},
+		{name: "dirlink/file.txt", policy: followNever, want: notFollowed},
+		{name: "allowlink", policy: followNever, want: notFollowed},
+		{name: "outlink", policy: followNever, want: notFollowed},
+
+		{name: "inlink", policy: followAllowed, want: ok},
+		{name: "allowlink", policy: followAllowed, want: ok},
+		{name: "allowdir/shared.txt", policy: followAllowed, want: ok},
+		{name: "outlink", policy: followAllowed, want: escapes},
+		{name: "allowkey", policy: followAllowed, want: denied},
+		{name: "allowkeys", policy: followAllowed, want: denied},
+		{name: "allowdir/private/keys/id_ed25519", policy: followAllowed, want: denied},
+		{name: "allowout", policy: followAllowed, want: escapes},
+		{name: "allowdir/private/secret.txt", policy: followAllowed, want: escapes},
+		{name: "dir/relallow", policy: followAllowed, want: ok},
+	}
+
+	for _, c := range cases {
+		rfs := openRootFS(t, rootDir, c.policy, []string{allowedDir})
+
+		for _, op := range []string{"open", "stat"} {
+			var err error
+			if op == "open" {
+				var f fs.File
+				f, err = rfs.Open(c.name)
+				if err == nil {
+					_, err = io.ReadAll(f)
+					f.Close()
+				}
+			} else {
+				_, err = rfs.Stat(c.name)
+			}
+
+			var got result
+			switch {
+			case err == nil:
+				got = ok
+			case pathEscapes(err):
+				got = escapes
+			case errors.Is(err, errSymlink):
+				got = notFollowed
+			case errors.Is(err, fs.ErrNotExist):
+				got = notFound
+			case errors.Is(err, errPolicyDenied):
+				got = denied
+			default:
+				t.Errorf("%s(%s) with policy %d failed with %s", op, c.name, c.policy, err)
+				continue
+			}
+			if got != c.want {
+				t.Errorf("%s(%s) with policy %d got %d, want %d (%v)", op, c.name, c.policy,
+					got, c.want, err)
+			}
+		}
+	}
+
+	rfs := openRootFS(t, rootDir, followAllowed, []string{allowedDir})
+	if entries, err := rfs.ReadDir("allowdir"); err != nil {
+		t.Errorf("ReadDir(allowdir) failed with %s", err)
+	} else if len(entries) != 2 || entries[1].Name() != "shared.txt" {
+		t.Errorf("ReadDir(allowdir) got %v", entries)
+	}
+
+	rfs = openRootFS(t, rootDir, followNever, nil)
+	if _, err := rfs.ReadDir("dirlink"); !errors.Is(err, errSymlink) {
+		t.Errorf("ReadDir(dirlink) got %v, want %s", err, errSymlink)
+	}
+	if fi, err := rfs.Lstat("dirlink"); err != nil {
+		t.Errorf("Lstat(dirlink) failed with %s", err)
+	} else if fi.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat(dirlink) got mode %s, want symbolic link", fi.Mode())
+	}
+	if target, err := rfs.Readlink("dirlink"); err != nil {
+		t.Errorf("Readlink(dirlink) failed with %s", err)
+	} else if target != "dir" {
+		t.Errorf("Readlink(dirlink) got %s, want dir", target)
+	}
+	if _, err := rfs.Readlink("dir"); err == nil {
+		t.Errorf("Readlink(dir) did not fail")
+	}
+}
+
+func TestSymlinkTools(t *testing.T) {
+	rootDir, _ := symlinkTree(t)
+	mustSymlink(t, filepath.Join(rootDir, "dir"), filepath.Join(rootDir, "abslink"))
+
+	for _, policy := range []symlinkPolicy{followInRoot, followNever} {
+		sf := &serverFactory{
+			fs:          openRootFS(t, rootDir, policy, nil),
+			serverRoots: []serverRoot{{dir: rootDir}},
+		}
+		opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+		_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+		want := map[string]map[string]any{
+			"dir":       {"isDir": true, "isSymlink": nil},
+			"inlink":    {"isDir": false, "isSymlink": true, "target": "dir/file.txt"},
+			"dirlink":   {"isDir": false, "isSymlink": true, "target": "dir"},
+			"abslink":   {"isDir": false, "isSymlink": true, "target": "dir", "blocked": true},
+			"outlink":   {"isSymlink": true, "target": nil, "blocked": true},
+			"allowlink": {"isSymlink": true, "target": nil, "blocked": true},
+			"dangling":  {"isSymlink": true, "target": "missing.txt", "blocked": nil},
+		}
+		if policy == followNever {
+			want["inlink"]["blocked"] = true
+			want["dirlink"]["blocked"] = true
+			want["dangling"]["blocked"] = true
+		} else {
+			want["inlink"]["blocked"] = nil
+			want["dirlink"]["blocked"] = nil
+		}
+
+		res := callTool(t, cs, "list_directory", map[string]any{"path": ""})
+		if res.IsError {
+			t.Fatalf("list_directory() failed with %v", res.Content)
+		}
+		entries := map[string]map[string]any{}
+		for _, e := range res.StructuredContent.(map[string]any)["entries"].([]any) {
+			entry := e.(map[string]any)
+			entries[entry["name"].(string)] = entry
+		}
+
+		for name, fields := range want {
+			entry, ok := entries[name]
+			if !ok {
+				t.Errorf("list_directory() with policy %d: missing %s", policy, name)
+				continue
+			}
+			for key, val := range fields {
+				if entry[key] != val {
+					t.Errorf("list_directory() with policy %d got %s %s %v, want %v", policy,
+						name, key, entry[key], val)
+				}
+			}
+
+			res := callTool(t, cs, "get_file_info", map[string]any{"path": name})
+			if res.IsError {
+				t.Errorf("get_file_info(%s) failed with %v", name, res.Content)
+				continue
+			}
+			out := res.StructuredContent.(map[string]any)
+			for key, val := range fields {
+				if out[key] != val {
+					t.Errorf("get_file_info(%s) with policy %d got %s %v, want %v", name,
+						policy, key, out[key], val)
+				}
+			}
+		}
+
+		res = callTool(t, cs, "read_file", map[string]any{"path": "outlink"})
+		if out, _ := res.StructuredContent.(map[string]any); !res.IsError ||
+			out["code"] != "path_escape" && out["code"] != "policy_denied" {
+
+			t.Errorf("read_file(outlink) with policy %d got %v", policy, res.StructuredContent)
+		}
+
+		res = callTool(t, cs, "read_file", map[string]any{"path": "inlink"})
+		if policy == followNever {
+			if out, _ := res.StructuredContent.(map[string]any); !res.IsError ||
+				out["code"] != "policy_denied" {
+
+				t.Errorf("read_file(inlink) with policy %d got %v", policy,
+					res.StructuredContent)
+			}
+		} else if res.IsError {
+			t.Errorf("read_file(inlink) failed with %v", res.Content)
+		}
+	}
+}
+
+func TestRealpath(t *testing.T) {
+	rootDir, _ := symlinkTree(t)
+	mustSymlink(t, "loop", filepath.Join(rootDir, "loop"))
+	mustSymlink(t, "../inlink", filepath.Join(rootDir, "dir", "up"))
+	mustSymlink(t, filepath.Join(rootDir, "dir"), filepath.Join(rootDir, "absdir"))
+	rfs := openRootFS(t, rootDir, followNever, nil)
+
+	cases := []struct {
+		name string
+		want string
+		fail bool
+	}{
+		{name: ".", want: "."},
+		{name: "dir/file.txt", want: "dir/file.txt"},
+		{name: "inlink", want: "dir/file.txt"},
+		{name: "dirlink/file.txt", want: "dir/file.txt"},
+		{name: "dir/up", want: "dir/file.txt"},
+		{name: "absdir/file.txt", want: "dir/file.txt"},
+		{name: "dangling", want: "missing.txt"},
+		{name: "missing/file.txt", want: "missing/file.txt"},
+		{name: "outlink", fail: true},
+		{name: "allowdir/shared.txt", fail: true},
+		{name: "loop", fail: true},
+		{name: "../root", fail: true},
+	}
+
+	for _, c := range cases {
+		rp, err := rfs.Realpath(c.name)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("Realpath(%s) failed with %s", c.name, err)
+			}
+		} else if c.fail {
+			t.Errorf("Realpath(%s) did not fail", c.name)
+		} else if rp != c.want {
+			t.Errorf("Realpath(%s) got %s, want %s", c.name, rp, c.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 365 * 24 * time.Hour
+
+	// certRenewal is how long before it expires that a self-signed certificate is replaced.
+	certRenewal = 30 * 24 * time.Hour
+)
+
+// clientCertConfig returns a TLS configuration which requires clients to present a
+// certificate signed by one of the certificate authorities in caFile.
+func clientCertConfig(caFile string) (*tls.Config, error) {
+	cnt, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(cnt) {
+		return nil, fmt.Errorf("%s: no certificates found", caFile)
+	}
+
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// certIdentity returns the identity of a client certificate: the common name of the subject,
+// or if there isn't one, the first subject alternative name.
+func certIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	} else if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	} else if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	} else if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	} else if len(cert.IPAddresses) > 0 {
+		return cert.IPAddresses[0].String()
+	}
+	return ""
+}
+
+// selfSignedCert returns the certificate and key files of a server certificate for
+// localhost and hosts, signed by a local certificate authority. The certificate authority
+// and the certificate are kept in the tls directory of stateDir and reused; the certificate
+// is replaced when it is about to expire or doesn't cover all of hosts.
+func selfSignedCert(stateDir string, hosts []string) (string, string, *x509.Certificate,
+	error) {
+
+	dir := filepath.Join(stateDir, "tls")
+	caFile := filepath.Join(dir, "ca.pem")
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	hostnames := []string{"localhost", "127.0.0.1", "::1"}
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if h != "" && !slices.Contains(hostnames, h) {
+			hostnames = append(hostnames, h)
+		}
+	}
+	hosts = hostnames
+
+	caCert, caKey, err := loadCert(caFile, caKeyFile)
+	if err != nil || !caCert.IsCA || time.Now().Add(certRenewal).After(caCert.NotAfter) {
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("replacing local CA", "file", caFile, "error", err)
+		}
+
+		caCert, caKey, err = createCert(&x509.Certificate{
+			Subject: pkix.Name{
+				Organization: []string{"filemcp"},
+				CommonName:   "filemcp local CA",
+			},
+			NotAfter:              time.Now().Add(caValidity),
+			IsCA:                  true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+		}, nil, nil, caFile, caKeyFile)
+		if err != nil {
+			return "", "", nil, err
+		}
+		slog.Info("created local CA", "file", caFile)
+	}
+
+	cert, _, err := loadCert(certFile, keyFile)
+	if err == nil && cert.CheckSignatureFrom(caCert) == nil &&
+		time.Now().Add(certRenewal).Before(cert.NotAfter) && certCovers(cert, hosts) {
+
+		return certFile, keyFile, caCert, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("replacing certificate", "file", certFile, "error", err)
+	}
+
+	tmpl := &x509.Certificate{
+		Subject: pkix.Name{
+			Organization: []string{"filemcp"},
+			CommonName:   hosts[0],
+		},
+		NotAfter:    time.Now().Add(certValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	_, _, err = createCert(tmpl, caCert, caKey, certFile, keyFile)
+	if err != nil {
+		return "", "", nil, err
+	}
+	slog.Info("created certificate", "file", certFile, "hosts", strings.Join(hosts, ","))
+	return certFile, keyFile, caCert, nil
+}
+
+// certCovers returns true if cert is valid for all of hosts.
+func certCovers(cert *x509.Certificate, hosts []string) bool {
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func loadCert(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: unsupported key", keyFile)
+	}
+	return cert, key, nil
+}
+
+// createCert creates a certificate from tmpl, signed by parent, or self-signed if parent is
+// nil, and writes it and its key to certFile and keyFile.
+func createCert(tmpl, parent *x509.Certificate, parentKey crypto.Signer, certFile,
+	keyFile string) (*x509.Certificate, crypto.Signer, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		parent = tmpl
+		parentKey = key
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY",
+		Bytes: keyDER}), 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writeFileAtomic(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: der}), 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// certFingerprint returns the SHA-256 fingerprint of cert, as colon separated hex bytes.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustCreateCert(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed with %s", err)
+	}
+	if parent == nil {
+		parent = tmpl
+		parentKey = key
+	}
+
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() failed with %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() failed with %s", err)
+	}
+	return cert, key
+}
+
+func TestCertIdentity(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.com/agent")
+
+	cases := []struct {
+		cert     *x509.Certificate
+		identity string
+	}{
+		{cert: &x509.Certificate{Subject: pkix.Name{CommonName: "alice"},
+			DNSNames: []string{"alice.example.com"}}, identity: "alice"},
+		{cert: &x509.Certificate{DNSNames: []string{"agent.example.com"}},
+			identity: "agent.example.com"},
+		{cert: &x509.Certificate{EmailAddresses: []string{"bob@example.com"}},
+			identity: "bob@example.com"},
+		{cert: &x509.Certificate{URIs: []*url.URL{u}}, identity: "spiffe://example.com/agent"},
+		{cert: &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}},
+			identity: "10.0.0.1"},
+		{cert: &x509.Certificate{}, identity: ""},
+	}
+
+	for _, c := range cases {
+		if identity := certIdentity(c.cert); identity != c.identity {
+			t.Errorf("certIdentity() got %s, want %s", identity, c.identity)
+		}
+	}
+}
+
+func TestClientCertConfig(t *testing.T) {
+	caCert, caKey := mustCreateCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	clientCert, clientKey := mustCreateCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alice"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, caKey)
+	otherCert, otherKey := mustCreateCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mallory"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	mustWriteFile(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: caCert.Raw}))
+
+	tlsConfig, err := clientCertConfig(caFile)
+	if err != nil {
+		t.Fatalf("clientCertConfig(%s) failed with %s", caFile, err)
+	}
+
+	var identity string
+	httpServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		identity = requestIdentity(r)
+	}))
+	httpServer.TLS = tlsConfig
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	cases := []struct {
+		cert     *x509.Certificate
+		key      *ecdsa.PrivateKey
+		identity string
+		fail     bool
+	}{
+		{cert: clientCert, key: clientKey, identity: "alice"},
+		{cert: otherCert, key: otherKey, fail: true},
+		{fail: true},
+	}
+
+	for _, c := range cases {
+		identity = ""
+		transport := httpServer.Client().Transport.(*http.Transport).Clone()
+		if c.cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{
+				{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key},
+			}
+		}
+		client := &http.Client{Transport: transport}
+
+		resp, err := client.Get(httpServer.URL)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("Get() failed with %s", err)
+			}
+			continue
+		}
+		resp.Body.Close()
+		if c.fail {
+			t.Errorf("Get() did not fail")
+		} else if identity != c.identity {
+			t.Errorf("Get() got identity %s, want %s", identity, c.identity)
+		}
+	}
+
+	_, err = clientCertConfig(filepath.Join(t.TempDir(), "missing.pem"))
+	if err == nil {
+		t.Errorf("clientCertConfig(missing.pem) did not fail")
+	}
+	emptyFile := filepath.Join(t.TempDir(), "empty.pem")
+	mustWriteFile(t, emptyFile, []byte("not a certificate"))
+	_, err = clientCertConfig(emptyFile)
+	if err == nil {
+		t.Errorf("clientCertConfig(empty.pem) did not fail")
+	}
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	stateDir := t.TempDir()
+
+	verify := func(certFile string, caCert *x509.Certificate, hosts ...string) []byte {
+		t.Helper()
+
+		cert, _, err := loadCert(certFile, filepath.Join(filepath.Dir(certFile), "key.pem"))
+		if err != nil {
+			t.Fatalf("loadCert(%s) failed with %s", certFile, err)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		for _, h := range hosts {
+			_, err = cert.Verify(x509.VerifyOptions{DNSName: h, Roots: roots})
+			if err != nil {
+				t.Errorf("Verify(%s) failed with %s", h, err)
+			}
+		}
+		return cert.Raw
+	}
+
+	certFile, keyFile, caCert, err := selfSignedCert(stateDir, nil)
+	if err != nil {
+		t.Fatalf("selfSignedCert() failed with %s", err)
+	}
+	if info, err := os.Stat(keyFile); err != nil {
+		t.Errorf("Stat(%s) failed with %s", keyFile, err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("Stat(%s) got %s, want 0600", keyFile, info.Mode().Perm())
+	}
+	raw := verify(certFile, caCert, "localhost", "127.0.0.1", "::1")
+
+	_, _, caCert2, err := selfSignedCert(stateDir, nil)
+	if err != nil {
+		t.Fatalf("selfSignedCert() failed with %s", err)
+	}
+	if certFingerprint(caCert2) != certFingerprint(caCert) {
+		t.Errorf("selfSignedCert() did not reuse the CA")
+	}
+	if raw2 := verify(certFile, caCert, "localhost"); string(raw2) != string(raw) {
+		t.Errorf("selfSignedCert() did not reuse the certificate")
+	}
+
+	_, _, caCert2, err = selfSignedCert(stateDir, []string{"files.example.com", "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("selfSignedCert() failed with %s", err)
+	}
+	if certFingerprint(caCert2) != certFingerprint(caCert) {
+		t.Errorf("selfSignedCert() did not reuse the CA")
+	}
+	raw2 := verify(certFile, caCert, "localhost", "files.example.com", "10.0.0.1")
+	if string(raw2) == string(raw) {
+		t.Errorf("selfSignedCert() did not replace the certificate for new hosts")
+	}
+
+	_, _, _, err = selfSignedCert(stateDir, []string{" files.example.com", "", "10.0.0.1 "})
+	if err != nil {
+		t.Fatalf("selfSignedCert() failed with %s", err)
+	}
+	if raw3 := verify(certFile, caCert, "files.example.com"); string(raw3) != string(raw2) {
+		t.Errorf("selfSignedCert() did not trim hosts")
+	}
+}
+
+func TestCertFingerprint(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("certificate")}
+	fp := certFingerprint(cert)
+	if len(fp) != 32*3-1 || fp[2] != ':' || fp != strings.ToUpper(fp) {
+		t.Errorf("certFingerprint() got %s", fp)
+	}
+}
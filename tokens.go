@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/auth"
+)
+
+// bearerToken is a shared secret which a client presents as a bearer token. The name
+// identifies the client, and allows tokens to be rotated by adding a new token before
+// removing the old one.
+type bearerToken struct {
+	name string
+	hash [sha256.Size]byte
+}
+
+// parseTokens parses tokens, one per entry, as name:token.
+func parseTokens(entries []string) ([]bearerToken, error) {
+	var tokens []bearerToken
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		name, token, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		token = strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("bad token: expected name:token")
+		}
+		tokens = append(tokens, bearerToken{
+			name: name,
+			hash: sha256.Sum256([]byte(token)),
+		})
+	}
+	return tokens, nil
+}
+
+// loadTokens loads tokens from file, one per line, and from the comma separated entries in
+// the FILEMCP_TOKENS environment variable.
+func loadTokens(file string) ([]bearerToken, error) {
+	var entries []string
+	if file != "" {
+		cnt, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = strings.Split(string(cnt), "\n")
+	}
+	if env := os.Getenv("FILEMCP_TOKENS"); env != "" {
+		entries = append(entries, strings.Split(env, ",")...)
+	}
+
+	return parseTokens(entries)
+}
+
+// verifyToken returns the name of the token which matches token. The comparison takes the
+// same amount of time, regardless of which token, if any, matches.
+func verifyToken(tokens []bearerToken, token string) (string, bool) {
+	hash := sha256.Sum256([]byte(token))
+
+	var name string
+	var found bool
+	for _, bt := range tokens {
+		if subtle.ConstantTimeCompare(bt.hash[:], hash[:]) == 1 {
+			name = bt.name
+			found = true
+		}
+	}
+	return name, found
+}
+
+// requireTokens returns middleware which requires each request to have one of the tokens,
+// returned by tokens, as a bearer token. The name of the token is the identity of the request.
+func requireTokens(tokens func() []bearerToken) func(http.Handler) http.Handler {
+	return auth.RequireBearerToken(
+		func(ctx context.Context, token string, r *http.Request) (*auth.TokenInfo, error) {
+			name, ok := verifyToken(tokens(), token)
+			if !ok {
+				return nil, auth.ErrInvalidToken
+			}
+
+			// Shared secrets don't expire, but RequireBearerToken requires an expiration.
+			return &auth.TokenInfo{
+				UserID:     name,
+				Expiration: time.Now().Add(time.Hour),
+			}, nil
+		}, nil)
+}
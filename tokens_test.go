@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseTokens(t *testing.T) {
+	cases := []struct {
+		entries []string
+		names   []string
+		fail    bool
+	}{
+		{entries: nil, names: nil},
+		{entries: []string{"alice:secret1"}, names: []string{"alice"}},
+		{entries: []string{"# comment", "", "alice:secret1", "  bob : secret2  "},
+			names: []string{"alice", "bob"}},
+		{entries: []string{"alice:secret:with:colons"}, names: []string{"alice"}},
+		{entries: []string{"nocolon"}, fail: true},
+		{entries: []string{":secret"}, fail: true},
+		{entries: []string{"alice:"}, fail: true},
+	}
+
+	for _, c := range cases {
+		tokens, err := parseTokens(c.entries)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("parseTokens(%v) failed with %s", c.entries, err)
+			}
+		} else if c.fail {
+			t.Errorf("parseTokens(%v) did not fail", c.entries)
+		} else {
+			var names []string
+			for _, bt := range tokens {
+				names = append(names, bt.name)
+			}
+			if !slices.Equal(names, c.names) {
+				t.Errorf("parseTokens(%v) got %v, want %v", c.entries, names, c.names)
+			}
+		}
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	tokensFile := filepath.Join(t.TempDir(), "tokens")
+	mustWriteFile(t, tokensFile, []byte("# tokens\nalice:secret1\nbob:secret2\n"))
+	t.Setenv("FILEMCP_TOKENS", "carol:secret3,dave:secret4")
+
+	tokens, err := loadTokens(tokensFile)
+	if err != nil {
+		t.Fatalf("loadTokens(%s) failed with %s", tokensFile, err)
+	}
+
+	cases := []struct {
+		token string
+		name  string
+		fail  bool
+	}{
+		{token: "secret1", name: "alice"},
+		{token: "secret2", name: "bob"},
+		{token: "secret3", name: "carol"},
+		{token: "secret4", name: "dave"},
+		{token: "secret", fail: true},
+		{token: "secret10", fail: true},
+		{token: "", fail: true},
+		{token: "alice", fail: true},
+	}
+
+	for _, c := range cases {
+		name, ok := verifyToken(tokens, c.token)
+		if !ok {
+			if !c.fail {
+				t.Errorf("verifyToken(%s) failed", c.token)
+			}
+		} else if c.fail {
+			t.Errorf("verifyToken(%s) did not fail", c.token)
+		} else if name != c.name {
+			t.Errorf("verifyToken(%s) got %s, want %s", c.token, name, c.name)
+		}
+	}
+
+	_, err = loadTokens(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Errorf("loadTokens(missing) did not fail")
+	}
+}
+
+func TestRequireTokens(t *testing.T) {
+	tokens, err := parseTokens([]string{"alice:secret1"})
+	if err != nil {
+		t.Fatalf("parseTokens() failed with %s", err)
+	}
+
+	var identity string
+	getTokens := func() []bearerToken { return tokens }
+	handler := requireTokens(getTokens)(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+
+		identity = requestIdentity(r)
+	}))
+
+	cases := []struct {
+		header   string
+		status   int
+		identity string
+	}{
+		{header: "Bearer secret1", status: http.StatusOK, identity: "alice"},
+		{header: "bearer secret1", status: http.StatusOK, identity: "alice"},
+		{header: "Bearer secret2", status: http.StatusUnauthorized},
+		{header: "Basic secret1", status: http.StatusUnauthorized},
+		{header: "", status: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		identity = ""
+		r := httptest.NewRequest("POST", "/mcp", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("Authorization: %s got status %d, want %d", c.header, w.Code, c.status)
+		} else if identity != c.identity {
+			t.Errorf("Authorization: %s got identity %s, want %s", c.header, identity,
+				c.identity)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errNegativeOffset = errors.New("offset must not be negative")
+
+// truncation describes what was omitted from a result which was cut to fit the response
+// size limits, and how to get the rest of it.
+type truncation struct {
+	Truncated  bool   `json:"truncated,omitempty" jsonschema:"true if the result was cut to fit the response size limit"`
+	Omitted    int    `json:"omitted,omitempty" jsonschema:"number of bytes or items which were omitted"`
+	NextOffset int    `json:"nextOffset,omitempty" jsonschema:"offset to request the rest of the result from"`
+	Hint       string `json:"hint,omitempty" jsonschema:"how to request the rest of the result"`
+}
+
+// limitMax returns the smaller of the server-wide limit and the limit requested by a call;
+// zero means no limit.
+func limitMax(server, requested int) int {
+	if requested > 0 && (server <= 0 || requested < server) {
+		return requested
+	}
+	return server
+}
+
+// truncateItems returns items starting at offset and at most maxItems of them, and what was
+// omitted after them.
+func truncateItems[T any](tool string, items []T, offset, maxItems int) ([]T, truncation,
+	error) {
+
+	if offset < 0 {
+		return nil, truncation{}, fmt.Errorf("%s: %w", tool, errNegativeOffset)
+	} else if offset > len(items) {
+		offset = len(items)
+	}
+	if items == nil {
+		// Tools return an empty list rather than null.
+		items = []T{}
+	}
+	items = items[offset:]
+	if maxItems <= 0 || len(items) <= maxItems {
+		return items, truncation{}, nil
+	}
+
+	next := offset + maxItems
+	return items[:maxItems], truncation{
+		Truncated:  true,
+		Omitted:    len(items) - maxItems,
+		NextOffset: next,
+		Hint: fmt.Sprintf("%d items omitted: call %s with offset %d to get the rest",
+			len(items)-maxItems, tool, next),
+	}, nil
+}
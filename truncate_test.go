@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestLimitMax(t *testing.T) {
+	cases := []struct {
+		server, requested, want int
+	}{
+		{server: 0, requested: 0, want: 0},
+		{server: 100, requested: 0, want: 100},
+		{server: 0, requested: 10, want: 10},
+		{server: 100, requested: 10, want: 10},
+		{server: 100, requested: 1000, want: 100},
+		{server: 100, requested: -1, want: 100},
+	}
+
+	for _, c := range cases {
+		if got := limitMax(c.server, c.requested); got != c.want {
+			t.Errorf("limitMax(%d, %d) got %d, want %d", c.server, c.requested, got, c.want)
+		}
+	}
+}
+
+func TestTruncateItems(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	cases := []struct {
+		offset   int
+		maxItems int
+		want     []string
+		next     int
+		omitted  int
+		fail     bool
+	}{
+		{want: items},
+		{maxItems: 5, want: items},
+		{maxItems: 2, want: []string{"a", "b"}, next: 2, omitted: 3},
+		{offset: 2, maxItems: 2, want: []string{"c", "d"}, next: 4, omitted: 1},
+		{offset: 4, maxItems: 2, want: []string{"e"}},
+		{offset: 5, want: []string{}},
+		{offset: -1, fail: true},
+	}
+
+	for _, c := range cases {
+		got, trunc, err := truncateItems("search_files", items, c.offset, c.maxItems)
+		if c.fail {
+			if err == nil {
+				t.Errorf("truncateItems(%d, %d) did not fail", c.offset, c.maxItems)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("truncateItems(%d, %d) failed with %s", c.offset, c.maxItems, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) || trunc.Truncated != (c.next > 0) ||
+			trunc.NextOffset != c.next || trunc.Omitted != c.omitted {
+
+			t.Errorf("truncateItems(%d, %d) got %v, %+v, want %v, next %d, omitted %d",
+				c.offset, c.maxItems, got, trunc, c.want, c.next, c.omitted)
+		}
+	}
+
+	got, _, err := truncateItems[string]("search_files", nil, 0, 0)
+	if err != nil {
+		t.Errorf("truncateItems(nil) failed with %s", err)
+	} else if got == nil {
+		t.Errorf("truncateItems(nil) got nil, want an empty list")
+	}
+}
+
+func TestTruncateTools(t *testing.T) {
+	tempDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(tempDir, "large.txt"), []byte(strings.Repeat("x", 100)))
+	for i := 0; i < 5; i++ {
+		mustWriteFile(t, filepath.Join(tempDir, "dir", fmt.Sprintf("file%d.txt", i)), nil)
+	}
+
+	sf := &serverFactory{
+		fs:          os.DirFS(tempDir),
+		serverRoots: []serverRoot{{dir: tempDir}},
+		maxBytes:    40,
+		maxItems:    3,
+	}
+	opts := &mcp.ClientOptions{Capabilities: &mcp.ClientCapabilities{}}
+	_, cs := connectClient(t, sf.newServer(sessionInfo{}), opts)
+
+	cases := []struct {
+		tool string
+		args map[string]any
+		want map[string]any
+	}{
+		{
+			tool: "read_file",
+			args: map[string]any{"path": "large.txt"},
+			want: map[string]any{"truncated": true, "omitted": 60.0, "nextOffset": 40.0},
+		},
+		{
+			tool: "read_file",
+			args: map[string]any{"path": "large.txt", "offset": 80},
+			want: map[string]any{"truncated": nil, "offset": 80.0},
+		},
+		{
+			tool: "read_file",
+			args: map[string]any{"path": "large.txt", "maxBytes": 1000},
+			want: map[string]any{"truncated": true, "nextOffset": 40.0},
+		},
+		{
+			tool: "list_directory",
+			args: map[string]any{"path": "dir"},
+			want: map[string]any{"truncated": true, "count": 3.0, "total": 5.0,
+				"nextOffset": 3.0},
+		},
+		{
+			tool: "list_directory",
+			args: map[string]any{"path": "dir", "offset": 3},
+			want: map[string]any{"truncated": nil, "count": 2.0, "total": 5.0},
+		},
+		{
+			tool: "search_files",
+			args: map[string]any{"pattern": "*.txt", "maxItems": 1},
+			want: map[string]any{"truncated": true, "count": 1.0, "total": 6.0,
+				"omitted": 5.0, "nextOffset": 1.0},
+		},
+	}
+
+	for _, c := range cases {
+		res := callTool(t, cs, c.tool, c.args)
+		if res.IsError {
+			t.Errorf("%s(%v) failed with %v", c.tool, c.args, res.Content)
+			continue
+		}
+		out := res.StructuredContent.(map[string]any)
+		for key, val := range c.want {
+			if out[key] != val {
+				t.Errorf("%s(%v) got %s %v, want %v", c.tool, c.args, key, out[key], val)
+			}
+		}
+		if out["truncated"] == true && out["hint"] == nil {
+			t.Errorf("%s(%v) got no hint", c.tool, c.args)
+		}
+	}
+}